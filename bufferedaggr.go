@@ -0,0 +1,222 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+package crypt
+
+import (
+	"io"
+	"sync"
+)
+
+// DefaultBufferedAggrSize defines default amount of pre-generated random data
+// kept by BufferedAggr.
+const DefaultBufferedAggrSize = 4096
+
+// BufferedAggrOptions defines options to create a BufferedAggr.
+type BufferedAggrOptions struct {
+	// Size defines how many bytes of pre-generated random data are kept.
+	// Defaults to DefaultBufferedAggrSize.
+	Size int
+
+	// LowWater defines the buffer fill level below which it is refilled in
+	// background. Defaults to half of Size.
+	LowWater int
+
+	// Metrics receives operational metrics. Defaults to discard them.
+	Metrics Metrics
+}
+
+// A BufferedAggr keeps pre-generated random data from a RandomAggr, or any
+// other reader, to hide latency of slow sources. It is refilled by a
+// background routine whenever its fill level drops below a low-water mark and
+// served bytes are erased from its buffer. It is safe for concurrent use.
+type BufferedAggr struct {
+	rnd      io.Reader
+	buf      []byte
+	start    int
+	size     int
+	lowWater int
+	err      error
+	closed   bool
+	metrics  Metrics
+	mutex    *sync.Mutex
+	cond     *sync.Cond
+	once     *sync.Once
+	done     chan bool
+}
+
+// NewBufferedAggr creates a new instance of BufferedAggr which prefetches
+// random data from specified reader using default options.
+func NewBufferedAggr(rnd io.Reader) *BufferedAggr {
+	return NewBufferedAggrWithOptions(rnd, BufferedAggrOptions{})
+}
+
+// NewBufferedAggrWithOptions creates a new instance of BufferedAggr which
+// prefetches random data from specified reader. Its background routine starts
+// filling the buffer immediately.
+func NewBufferedAggrWithOptions(
+	rnd io.Reader, opts BufferedAggrOptions,
+) *BufferedAggr {
+	if opts.Size < 1 {
+		opts.Size = DefaultBufferedAggrSize
+	}
+	if opts.LowWater < 1 || opts.LowWater >= opts.Size {
+		opts.LowWater = opts.Size / 2
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = nopMetrics{}
+	}
+
+	mutex := &sync.Mutex{}
+	result := &BufferedAggr{
+		rnd:      rnd,
+		buf:      make([]byte, opts.Size),
+		lowWater: opts.LowWater,
+		metrics:  opts.Metrics,
+		mutex:    mutex,
+		cond:     sync.NewCond(mutex),
+		once:     &sync.Once{},
+		done:     make(chan bool, 0),
+	}
+	go result.refill()
+
+	return result
+}
+
+// Buffered returns how many bytes of pre-generated random data are available.
+func (s *BufferedAggr) Buffered() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.size
+}
+
+// Close stops background refill routine, closes underlying reader when it
+// implements io.Closer and erases the buffer. It is safe to call Close
+// concurrently and more than once.
+func (s *BufferedAggr) Close() error {
+	var err error
+	s.once.Do(func() {
+		s.mutex.Lock()
+		s.closed = true
+		s.cond.Broadcast()
+		s.mutex.Unlock()
+
+		// Closing the reader unblocks a refill waiting for slow sources
+		if closer, ok := s.rnd.(io.Closer); ok {
+			err = closer.Close()
+		}
+		<-s.done
+
+		s.mutex.Lock()
+		zero(s.buf)
+		s.start, s.size = 0, 0
+		s.metrics.Set(MetricBufferedAggrFill, "", 0)
+		s.mutex.Unlock()
+	})
+
+	return err
+}
+
+// Read fills specified buffer with pre-generated random data, waiting for
+// background refill when the buffer is drained.
+//
+// Returns ErrClosed when current instance is closed and errors from underlying
+// reader once data read before the error is served.
+func (s *BufferedAggr) Read(b []byte) (n int, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	waited := false
+	for n < len(b) {
+		if s.closed {
+			return n, ErrClosed
+		}
+		if s.size == 0 {
+			if s.err != nil {
+				err, s.err = s.err, nil
+				s.cond.Broadcast()
+				return n, err
+			}
+			if !waited {
+				waited = true
+				s.metrics.Add(MetricBufferedAggrUnderruns, "", 1)
+			}
+			s.cond.Wait()
+			continue
+		}
+
+		end := s.start + s.size
+		if end > len(s.buf) {
+			end = len(s.buf)
+		}
+		c := copy(b[n:], s.buf[s.start:end])
+		zero(s.buf[s.start : s.start+c])
+		n += c
+		s.start = (s.start + c) % len(s.buf)
+		s.size -= c
+
+		s.metrics.Set(MetricBufferedAggrFill, "", int64(s.size))
+		if s.size < s.lowWater {
+			s.cond.Broadcast()
+		}
+	}
+
+	return n, nil
+}
+
+// refill fills the buffer from underlying reader whenever its fill level drops
+// below low-water mark, until current instance is closed.
+func (s *BufferedAggr) refill() {
+	defer close(s.done)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for {
+		for !s.closed && (s.err != nil || s.size >= s.lowWater) {
+			s.cond.Wait()
+		}
+		if s.closed {
+			return
+		}
+
+		for !s.closed && s.err == nil && s.size < len(s.buf) {
+			// Only this routine writes to the free region, so it can be
+			// filled without holding the lock
+			begin := (s.start + s.size) % len(s.buf)
+			end := begin + len(s.buf) - s.size
+			if end > len(s.buf) {
+				end = len(s.buf)
+			}
+
+			s.mutex.Unlock()
+			c, err := io.ReadFull(s.rnd, s.buf[begin:end])
+			s.mutex.Lock()
+
+			if s.closed {
+				return
+			}
+			s.size += c
+			s.err = err
+			s.metrics.Set(MetricBufferedAggrFill, "", int64(s.size))
+			s.cond.Broadcast()
+		}
+	}
+}
+
+var _ io.ReadCloser = (*BufferedAggr)(nil)
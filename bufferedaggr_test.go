@@ -0,0 +1,265 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+package crypt
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A blockingSource blocks reads until it is closed.
+type blockingSource struct {
+	closed chan bool
+	once   sync.Once
+}
+
+func (s *blockingSource) Read(b []byte) (int, error) {
+	<-s.closed
+	return 0, ErrClosed
+}
+
+func (s *blockingSource) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+// waitBuffered waits until specified BufferedAggr has size bytes available.
+func waitBuffered(t *testing.T, rnd *BufferedAggr, size int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for rnd.Buffered() != size {
+		if time.Now().After(deadline) {
+			t.Fatalf("Should buffer %d bytes: got %d", size, rnd.Buffered())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBufferedAggrOptions(t *testing.T) {
+	tests := []struct {
+		opts     BufferedAggrOptions
+		size     int
+		lowWater int
+	}{
+		{BufferedAggrOptions{}, DefaultBufferedAggrSize, DefaultBufferedAggrSize / 2},
+		{BufferedAggrOptions{Size: 100}, 100, 50},
+		{BufferedAggrOptions{Size: 100, LowWater: 10}, 100, 10},
+		{BufferedAggrOptions{Size: 100, LowWater: 100}, 100, 50},
+		{BufferedAggrOptions{Size: -1, LowWater: -1}, DefaultBufferedAggrSize, DefaultBufferedAggrSize / 2},
+	}
+
+	for i, v := range tests {
+		rnd := NewBufferedAggrWithOptions(InfiniteSource(1), v.opts)
+		if len(rnd.buf) != v.size || rnd.lowWater != v.lowWater {
+			t.Errorf("Test %d: unexpected size and low-water: got %d and %d",
+				i, len(rnd.buf), rnd.lowWater)
+		}
+		rnd.Close()
+	}
+}
+
+func TestBufferedAggrSequence(t *testing.T) {
+	rnd := NewBufferedAggrWithOptions(&CountingSource{},
+		BufferedAggrOptions{Size: 256, LowWater: 64})
+	defer rnd.Close()
+	waitBuffered(t, rnd, 256)
+
+	// Odd read sizes cross buffer boundary at different offsets
+	next := byte(1)
+	for i := 0; i < 100; i++ {
+		buf := make([]byte, 1+i%37)
+		if n, err := rnd.Read(buf); err != nil || n != len(buf) {
+			t.Fatalf("Error reading %d bytes: got %d and %v", len(buf), n, err)
+		}
+		for j, v := range buf {
+			if v != next {
+				t.Fatalf("Read %d: byte %d should be %d: got %d",
+					i, j, next, v)
+			}
+			next++
+		}
+	}
+}
+
+func TestBufferedAggrRefill(t *testing.T) {
+	reg := NewMetricsRegistry()
+	source := &FailingSource{1, 100, io.ErrUnexpectedEOF}
+	rnd := NewBufferedAggrWithOptions(source,
+		BufferedAggrOptions{Size: 100, LowWater: 40, Metrics: reg})
+	defer rnd.Close()
+	waitBuffered(t, rnd, 100)
+
+	buf := make([]byte, 50)
+	if n, err := rnd.Read(buf); err != nil || n != 50 {
+		t.Fatalf("Error reading buffered data: got %d and %v", n, err)
+	}
+	if got := reg.Value(MetricBufferedAggrFill, ""); got != 50 {
+		t.Errorf("Should measure fill level: got %d", got)
+	}
+
+	// Above low-water mark no refill is tried
+	source.size = 100
+	time.Sleep(10 * time.Millisecond)
+	if n := rnd.Buffered(); n != 50 {
+		t.Errorf("Should not refill above low-water mark: got %d", n)
+	}
+
+	buf = make([]byte, 20)
+	rnd.Read(buf)
+	waitBuffered(t, rnd, 100)
+	if got := reg.Value(MetricBufferedAggrFill, ""); got != 100 {
+		t.Errorf("Should measure fill level: got %d", got)
+	}
+}
+
+func TestBufferedAggrErase(t *testing.T) {
+	rnd := NewBufferedAggrWithOptions(&FailingSource{1, 100, io.EOF},
+		BufferedAggrOptions{Size: 100, LowWater: 40})
+	defer rnd.Close()
+	waitBuffered(t, rnd, 100)
+
+	buf := make([]byte, 70)
+	rnd.Read(buf)
+
+	rnd.mutex.Lock()
+	for i, v := range rnd.buf {
+		served := i < rnd.start || i >= rnd.start+rnd.size
+		if served && v != 0 {
+			t.Errorf("Served byte %d should be erased: got %d", i, v)
+		}
+	}
+	rnd.mutex.Unlock()
+}
+
+func TestBufferedAggrError(t *testing.T) {
+	errFake := errors.New("fake error")
+	source := &FailingSource{1, 60, errFake}
+	rnd := NewBufferedAggrWithOptions(source,
+		BufferedAggrOptions{Size: 100, LowWater: 40})
+	defer rnd.Close()
+
+	buf := make([]byte, 100)
+	n, err := rnd.Read(buf)
+	if n != 60 || err != errFake {
+		t.Errorf("Should serve buffered data before error: got %d and %v",
+			n, err)
+	}
+
+	// Error is reported once and refill is retried afterwards
+	source.size = 100
+	if n, err := rnd.Read(buf[:10]); err != nil || n != 10 {
+		t.Errorf("Should retry refill after error: got %d and %v", n, err)
+	}
+}
+
+func TestBufferedAggrUnderrun(t *testing.T) {
+	reg := NewMetricsRegistry()
+	latency := LatencySource(10 * time.Millisecond)
+	rnd := NewBufferedAggrWithOptions(&latency,
+		BufferedAggrOptions{Size: 64, Metrics: reg})
+	defer rnd.Close()
+
+	buf := make([]byte, 64)
+	rnd.Read(buf)
+	if got := reg.Value(MetricBufferedAggrUnderruns, ""); got != 1 {
+		t.Errorf("Should count reads waiting for refill: got %d", got)
+	}
+}
+
+func TestBufferedAggrClose(t *testing.T) {
+	source := &blockingSource{closed: make(chan bool)}
+	rnd := NewBufferedAggr(source)
+
+	result := make(chan error)
+	go func() {
+		_, err := rnd.Read(make([]byte, 32))
+		result <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	rnd.Close()
+	select {
+	case err := <-result:
+		if err != ErrClosed {
+			t.Errorf("Should return ErrClosed: got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close should unblock waiting reads")
+	}
+
+	if n := rnd.Buffered(); n != 0 {
+		t.Errorf("Buffer should be erased: got %d bytes", n)
+	}
+	if err := rnd.Close(); err != nil {
+		t.Errorf("Close should be idempotent: got %v", err)
+	}
+}
+
+func TestBufferedAggrConcurrent(t *testing.T) {
+	aggr := NewRandomAggr().
+		Add(&CountingSource{}, 1).
+		Add(InfiniteSource(1), 1).
+		Build()
+	rnd := NewBufferedAggrWithOptions(aggr, BufferedAggrOptions{Size: 128})
+	defer rnd.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, DefaultTokenSize)
+			for j := 0; j < 100; j++ {
+				if n, err := rnd.Read(buf); err != nil || n != len(buf) {
+					t.Errorf("Error reading: got %d and %v", n, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func benchmarkBufferedAggr(b *testing.B, buffered bool) {
+	builder := NewRandomAggr().Parallel(true)
+	for i := 0; i < 4; i++ {
+		latency := LatencySource(50 * time.Microsecond)
+		builder.Add(&latency, 1)
+	}
+	var rnd io.ReadCloser = builder.Build()
+	if buffered {
+		rnd = NewBufferedAggr(rnd)
+	}
+	defer rnd.Close()
+	buf := make([]byte, DefaultTokenSize)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		rnd.Read(buf)
+	}
+}
+
+func BenchmarkBufferedAggr(b *testing.B) {
+	benchmarkBufferedAggr(b, true)
+}
+
+func BenchmarkBufferedAggrUnbuffered(b *testing.B) {
+	benchmarkBufferedAggr(b, false)
+}
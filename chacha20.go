@@ -0,0 +1,271 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+const (
+	// chachaKeySize defines the key size of ChaCha20 cipher.
+	chachaKeySize = 32
+
+	// chachaBlockSize defines the size of each ChaCha20 output block.
+	chachaBlockSize = 64
+
+	// chachaBufferBlocks defines how many blocks are generated at once. The
+	// first 32 bytes of each batch replace current key.
+	chachaBufferBlocks = 16
+
+	// DefaultChaCha20ReseedBytes defines how many bytes ChaCha20 serves before
+	// reseeding by default.
+	DefaultChaCha20ReseedBytes = 1 << 24
+
+	// DefaultChaCha20ReseedInterval defines the maximum time between ChaCha20
+	// reseeds by default.
+	DefaultChaCha20ReseedInterval = time.Minute
+)
+
+// ChaCha20Options defines when a ChaCha20 generator reseeds.
+type ChaCha20Options struct {
+	// Clock measures time between reseeds. Defaults to system clock.
+	Clock Clock
+
+	// ReseedBytes defines how many bytes are served before reseeding.
+	// Defaults to DefaultChaCha20ReseedBytes.
+	ReseedBytes int64
+
+	// ReseedInterval defines the maximum time between reseeds. Defaults to
+	// DefaultChaCha20ReseedInterval.
+	ReseedInterval time.Duration
+}
+
+// A ChaCha20 provides a fast pseudo-random generator using ChaCha20 stream
+// cipher with fast key erasure: every batch of generated blocks replaces the
+// key used to generate it, and served bytes are erased from its buffer. It is
+// seeded from another source, like a RandomAggr, and is safe for concurrent
+// use.
+type ChaCha20 struct {
+	seed           io.Reader
+	key            [chachaKeySize]byte
+	buf            [chachaBlockSize * chachaBufferBlocks]byte
+	avail          int
+	seeded         bool
+	served         int64
+	reseedBytes    int64
+	reseedInterval time.Duration
+	lastReseed     time.Time
+	clock          Clock
+	closed         bool
+	mutex          *sync.Mutex
+}
+
+// NewChaCha20 creates a new instance of ChaCha20 which is seeded from
+// specified source.
+func NewChaCha20(seed io.Reader) *ChaCha20 {
+	return NewChaCha20WithOptions(seed, ChaCha20Options{})
+}
+
+// NewChaCha20WithOptions creates a new instance of ChaCha20 which is seeded
+// from specified source as specified by options.
+func NewChaCha20WithOptions(seed io.Reader, opts ChaCha20Options) *ChaCha20 {
+	if opts.Clock == nil {
+		opts.Clock = systemClock{}
+	}
+	if opts.ReseedBytes < 1 {
+		opts.ReseedBytes = DefaultChaCha20ReseedBytes
+	}
+	if opts.ReseedInterval <= 0 {
+		opts.ReseedInterval = DefaultChaCha20ReseedInterval
+	}
+
+	return &ChaCha20{
+		seed:           seed,
+		reseedBytes:    opts.ReseedBytes,
+		reseedInterval: opts.ReseedInterval,
+		clock:          opts.Clock,
+		mutex:          &sync.Mutex{},
+	}
+}
+
+// Close closes seed source if it implements io.Closer and erases internal
+// state.
+func (s *ChaCha20) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+	zero(s.key[:], s.buf[:])
+	s.avail = 0
+
+	if closer, ok := s.seed.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Read fills specified byte array with random data. Current instance is
+// seeded on first call and reseeded when too many bytes were served, even
+// within a single call, or reseed interval has elapsed.
+//
+// Returns ErrClosed when current instance is closed.
+func (s *ChaCha20) Read(b []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	stale := !s.seeded ||
+		s.clock.Now().Sub(s.lastReseed) >= s.reseedInterval
+
+	n := 0
+	for n < len(b) {
+		if stale || s.served >= s.reseedBytes {
+			if err := s.reseed(); err != nil {
+				return n, err
+			}
+			stale = false
+		}
+		if s.avail == 0 {
+			s.refill()
+		}
+
+		chunk := b[n:]
+		if left := s.reseedBytes - s.served; int64(len(chunk)) > left {
+			chunk = chunk[:left]
+		}
+
+		start := len(s.buf) - s.avail
+		c := copy(chunk, s.buf[start:])
+		zero(s.buf[start : start+c])
+		s.avail -= c
+		s.served += int64(c)
+		n += c
+	}
+
+	return n, nil
+}
+
+// Reseed mixes new data from seed source into current key and discards
+// buffered output.
+//
+// Returns ErrClosed when current instance is closed.
+func (s *ChaCha20) Reseed() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	return s.reseed()
+}
+
+// reseed replaces current key by hashing it with new data from seed source.
+func (s *ChaCha20) reseed() error {
+	var seed [chachaKeySize]byte
+	defer zero(seed[:])
+
+	if _, err := io.ReadFull(s.seed, seed[:]); err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	hash.Write(s.key[:])
+	hash.Write(seed[:])
+	hash.Sum(s.key[:0])
+
+	zero(s.buf[:])
+	s.avail = 0
+	s.seeded = true
+	s.served = 0
+	s.lastReseed = s.clock.Now()
+	return nil
+}
+
+// refill generates a batch of blocks, replaces current key by its first bytes
+// and keeps the remaining as buffered output.
+func (s *ChaCha20) refill() {
+	var block [chachaBlockSize]byte
+	for i := 0; i < chachaBufferBlocks; i++ {
+		chachaBlock(&block, &s.key, uint64(i))
+		copy(s.buf[i*chachaBlockSize:], block[:])
+	}
+	zero(block[:])
+
+	copy(s.key[:], s.buf[:chachaKeySize])
+	zero(s.buf[:chachaKeySize])
+	s.avail = len(s.buf) - chachaKeySize
+}
+
+// chachaBlock computes the ChaCha20 block for specified key and 64-bit block
+// counter, using a zero nonce.
+func chachaBlock(out *[chachaBlockSize]byte, key *[chachaKeySize]byte,
+	counter uint64) {
+	var state, x [16]uint32
+
+	state[0], state[1], state[2], state[3] =
+		0x61707865, 0x3320646e, 0x79622d32, 0x6b206574
+	for i := 0; i < 8; i++ {
+		state[4+i] = binary.LittleEndian.Uint32(key[i*4:])
+	}
+	state[12] = uint32(counter)
+	state[13] = uint32(counter >> 32)
+
+	x = state
+	for i := 0; i < 10; i++ {
+		chachaQuarterRound(&x, 0, 4, 8, 12)
+		chachaQuarterRound(&x, 1, 5, 9, 13)
+		chachaQuarterRound(&x, 2, 6, 10, 14)
+		chachaQuarterRound(&x, 3, 7, 11, 15)
+		chachaQuarterRound(&x, 0, 5, 10, 15)
+		chachaQuarterRound(&x, 1, 6, 11, 12)
+		chachaQuarterRound(&x, 2, 7, 8, 13)
+		chachaQuarterRound(&x, 3, 4, 9, 14)
+	}
+
+	for i := range x {
+		binary.LittleEndian.PutUint32(out[i*4:], x[i]+state[i])
+	}
+}
+
+// chachaQuarterRound applies ChaCha quarter round to specified state words.
+func chachaQuarterRound(x *[16]uint32, a, b, c, d int) {
+	x[a] += x[b]
+	x[d] = bits.RotateLeft32(x[d]^x[a], 16)
+	x[c] += x[d]
+	x[b] = bits.RotateLeft32(x[b]^x[c], 12)
+	x[a] += x[b]
+	x[d] = bits.RotateLeft32(x[d]^x[a], 8)
+	x[c] += x[d]
+	x[b] = bits.RotateLeft32(x[b]^x[c], 7)
+}
+
+var _ io.ReadCloser = (*ChaCha20)(nil)
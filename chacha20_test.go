@@ -0,0 +1,226 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestChaCha20Block(t *testing.T) {
+	// RFC 8439 appendix A.1, test vectors #1 and #2.
+	expected := []string{
+		"76b8e0ada0f13d90405d6ae55386bd28bdd219b8a08ded1aa836efcc8b770dc7" +
+			"da41597c5157488d7724e03fb8d84a376a43b8f41518a11cc387b669b2ee6586",
+		"9f07e7be5551387a98ba977c732d080dcb0f29a048e3656912c6533e32ee7aed" +
+			"29b721769ce64e43d57133b074d839d531ed1f28510afb45ace10a1f4b794d6f",
+	}
+
+	var key [chachaKeySize]byte
+	var block [chachaBlockSize]byte
+	for i, v := range expected {
+		chachaBlock(&block, &key, uint64(i))
+		if !bytes.Equal(block[:], mustDecodeHex(v)) {
+			t.Errorf("Block %d: unexpected output %x", i, block)
+		}
+	}
+}
+
+func TestChaCha20KeyErasure(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, chachaKeySize)
+	rnd := NewChaCha20(bytes.NewReader(seed))
+
+	var key [chachaKeySize]byte
+	hash := sha256.New()
+	hash.Write(key[:])
+	hash.Write(seed)
+	hash.Sum(key[:0])
+
+	var expected []byte
+	for batch := 0; batch < 2; batch++ {
+		var stream []byte
+		var block [chachaBlockSize]byte
+		for i := 0; i < chachaBufferBlocks; i++ {
+			chachaBlock(&block, &key, uint64(i))
+			stream = append(stream, block[:]...)
+		}
+		copy(key[:], stream)
+		expected = append(expected, stream[chachaKeySize:]...)
+	}
+
+	buf := make([]byte, len(expected))
+	if _, err := rnd.Read(buf[:100]); err != nil {
+		t.Fatalf("Error reading random data: %v", err)
+	}
+	if _, err := rnd.Read(buf[100:]); err != nil {
+		t.Fatalf("Error reading random data: %v", err)
+	}
+	if !bytes.Equal(buf, expected) {
+		t.Error("Should replace key by first bytes of each batch")
+	}
+	if !bytes.Equal(rnd.key[:], key[:]) {
+		t.Error("Should keep only the key of next batch")
+	}
+	for _, v := range rnd.buf {
+		if v != 0 {
+			t.Fatal("Served bytes should be erased from buffer")
+		}
+	}
+}
+
+func TestChaCha20ReseedBytes(t *testing.T) {
+	source := &LimitedSource{1, chachaKeySize * 4}
+	rnd := NewChaCha20WithOptions(source, ChaCha20Options{ReseedBytes: 100})
+
+	buf := make([]byte, 60)
+	for i := 0; i < 4; i++ {
+		if _, err := rnd.Read(buf); err != nil {
+			t.Fatalf("Request %d: error reading random data: %v", i, err)
+		}
+	}
+	if source.size != chachaKeySize {
+		t.Errorf("Should reseed after 100 bytes: %d bytes left", source.size)
+	}
+
+	if err := rnd.Reseed(); err != nil {
+		t.Fatalf("Error reseeding: %v", err)
+	}
+	if _, err := rnd.Read(buf); err != nil {
+		t.Fatalf("Error reading random data: %v", err)
+	}
+	if n, err := rnd.Read(buf); n != 40 || err != io.EOF {
+		t.Errorf("Should fail without seed data: read %d bytes: %v", n, err)
+	}
+}
+
+func TestChaCha20ReseedBytesLargeRead(t *testing.T) {
+	source := &LimitedSource{1, chachaKeySize * 3}
+	rnd := NewChaCha20WithOptions(source, ChaCha20Options{ReseedBytes: 100})
+
+	buf := make([]byte, 300)
+	if n, err := rnd.Read(buf); n != len(buf) || err != nil {
+		t.Fatalf("Should fill entire buffer: read %d bytes: %v", n, err)
+	}
+	if source.size != 0 {
+		t.Errorf("Should reseed every 100 bytes within a read: %d bytes left",
+			source.size)
+	}
+
+	if n, err := rnd.Read(buf[:1]); n != 0 || err != io.EOF {
+		t.Errorf("Should fail without seed data: read %d bytes: %v", n, err)
+	}
+}
+
+func TestChaCha20ReseedInterval(t *testing.T) {
+	clock := newFakeClock()
+	source := &LimitedSource{1, chachaKeySize * 2}
+	rnd := NewChaCha20WithOptions(source, ChaCha20Options{
+		Clock:          clock,
+		ReseedInterval: time.Second,
+	})
+
+	buf := make([]byte, 16)
+	rnd.Read(buf)
+	clock.now = clock.now.Add(time.Second - 1)
+	rnd.Read(buf)
+	if source.size != chachaKeySize {
+		t.Errorf("Should not reseed before interval: %d bytes left",
+			source.size)
+	}
+
+	clock.now = clock.now.Add(1)
+	rnd.Read(buf)
+	if source.size != 0 {
+		t.Errorf("Should reseed after interval: %d bytes left", source.size)
+	}
+}
+
+func TestChaCha20Closed(t *testing.T) {
+	aggr := NewRandomAggr().SecureSet()
+	rnd := NewChaCha20(aggr)
+	rnd.Read(make([]byte, 1))
+
+	rnd.Close()
+	rnd.Close()
+	if _, err := rnd.Read(make([]byte, 1)); err != ErrClosed {
+		t.Errorf("Read should return ErrClosed: got %v", err)
+	}
+	if err := rnd.Reseed(); err != ErrClosed {
+		t.Errorf("Reseed should return ErrClosed: got %v", err)
+	}
+	if _, err := aggr.Read(make([]byte, 1)); err == nil {
+		t.Error("Should close seed source")
+	}
+}
+
+func TestChaCha20Concurrent(t *testing.T) {
+	rnd := NewChaCha20(NewRandomAggr().FastSet())
+	defer rnd.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 100)
+			for j := 0; j < 100; j++ {
+				if _, err := rnd.Read(buf); err != nil {
+					t.Errorf("Error reading random data: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestChaCha20Unpredictability(t *testing.T) {
+	rnd := NewChaCha20(NewRandomAggr().SecureSet())
+	defer rnd.Close()
+
+	dups, stddev := testUnpred(rnd)
+
+	if dups > MaximumDups {
+		t.Errorf(
+			"ChaCha20 random generator: %d dups of %d",
+			int(TestingRounds*dups), TestingRounds)
+	}
+	if stddev < MinimumStandardDeviation {
+		t.Errorf(
+			"ChaCha20 random generator: %.2f STDDEV (%.2f minimum)",
+			stddev, MinimumStandardDeviation)
+	}
+}
+
+func BenchmarkChaCha20(b *testing.B) {
+	rnd := NewChaCha20(NewRandomAggr().SecureSet())
+	buf := make([]byte, DefaultTokenSize)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		rnd.Read(buf)
+	}
+
+	b.StopTimer()
+	rnd.Close()
+}
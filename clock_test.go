@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fakeClock implements Clock by advancing time by scripted deltas on every
+// timer request. Once the script is exhausted its timers never fire.
+type fakeClock struct {
+	mutex     sync.Mutex
+	now       time.Time
+	deltas    []time.Duration
+	requested []time.Duration
+	blocked   int
+}
+
+// newFakeClock creates a fakeClock whose timers measure specified samples as
+// sleep time deltas.
+func newFakeClock(samples ...byte) *fakeClock {
+	deltas := make([]time.Duration, len(samples))
+	for i, v := range samples {
+		deltas[i] = time.Duration(v) * 100
+	}
+
+	return &fakeClock{
+		now:    time.Unix(0, 0),
+		deltas: deltas,
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.requested = append(c.requested, d)
+	if len(c.deltas) == 0 {
+		c.blocked++
+		return nil
+	}
+
+	c.now = c.now.Add(c.deltas[0])
+	c.deltas = c.deltas[1:]
+
+	result := make(chan time.Time, 1)
+	result <- c.now
+	return result
+}
+
+// wait blocks until all scripted deltas were consumed.
+func (c *fakeClock) wait() {
+	c.waitBlocked(1)
+}
+
+// waitBlocked blocks until specified count of routines are waiting for timers
+// which never fire.
+func (c *fakeClock) waitBlocked(count int) {
+	for {
+		c.mutex.Lock()
+		blocked := c.blocked
+		c.mutex.Unlock()
+
+		if blocked >= count {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// sequence returns count samples without repeated consecutive values.
+func sequence(count int) []byte {
+	result := make([]byte, count)
+	for i := range result {
+		result[i] = byte(i)
+	}
+
+	return result
+}
+
+func TestSystemClock(t *testing.T) {
+	var clock Clock = systemClock{}
+
+	before := clock.Now()
+	<-clock.After(time.Millisecond)
+	if diff := clock.Now().Sub(before); diff < time.Millisecond {
+		t.Errorf("Timer should wait for specified duration: %v", diff)
+	}
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+const (
+	// jitterMemorySize defines the size of memory accessed to measure timing
+	// jitter.
+	jitterMemorySize = 8192
+
+	// jitterMemoryStep defines the distance between memory accesses, which is
+	// coprime to memory size to visit every position.
+	jitterMemoryStep = 67
+
+	// jitterMemoryAccesses defines how many memory accesses are made on every
+	// measurement.
+	jitterMemoryAccesses = 128
+
+	// jitterSamplesPerBlock defines how many time deltas are gathered to
+	// generate a block of random data, crediting one bit for each.
+	jitterSamplesPerBlock = sha256.Size * 8
+
+	// jitterMaxStuck defines how many consecutive stuck measurements are
+	// allowed before giving up.
+	jitterMaxStuck = 1024
+)
+
+// ErrNoJitter is returned by CPUJitter when timer cannot measure CPU timing
+// jitter.
+var ErrNoJitter = errors.New("CPU timing jitter is insufficient")
+
+// A CPUJitter provides a pseudo-random generator based on CPU timing jitter of
+// memory accesses and hashing operations, modeled on jitterentropy.
+type CPUJitter struct {
+	clock     Clock
+	memory    [jitterMemorySize]byte
+	index     int
+	seed      [sha256.Size]byte
+	block     [sha256.Size]byte
+	avail     int
+	lastDelta int64
+	lastDiff  int64
+	closed    bool
+	mutex     *sync.Mutex
+}
+
+// NewCPUJitter creates a new instance of CPUJitter.
+func NewCPUJitter() *CPUJitter {
+	return NewCPUJitterWithClock(systemClock{})
+}
+
+// NewCPUJitterWithClock creates a new instance of CPUJitter which uses
+// specified clock to measure timing jitter.
+func NewCPUJitterWithClock(clock Clock) *CPUJitter {
+	return &CPUJitter{
+		clock: clock,
+		mutex: &sync.Mutex{},
+	}
+}
+
+// Close erases internal state. Further calls to Read returns ErrClosed.
+func (s *CPUJitter) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	s.erase()
+	return nil
+}
+
+// Read fills specified byte array with random data.
+//
+// Returns ErrNoJitter when timer resolution is too coarse to measure timing
+// jitter.
+func (s *CPUJitter) Read(b []byte) (n int, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	for n < len(b) {
+		if s.avail == 0 {
+			if err = s.generate(); err != nil {
+				return
+			}
+		}
+
+		start := len(s.block) - s.avail
+		c := copy(b[n:], s.block[start:])
+		for i := start; i < start+c; i++ {
+			s.block[i] = 0
+		}
+
+		s.avail -= c
+		n += c
+	}
+
+	return n, nil
+}
+
+// erase zeroes internal state.
+func (s *CPUJitter) erase() {
+	for i := range s.memory {
+		s.memory[i] = 0
+	}
+	for i := range s.seed {
+		s.seed[i] = 0
+	}
+	for i := range s.block {
+		s.block[i] = 0
+	}
+	s.avail = 0
+	s.lastDelta = 0
+	s.lastDiff = 0
+}
+
+// generate gathers timing jitter samples to fill a new block of random data.
+func (s *CPUJitter) generate() error {
+	hash := sha256.New()
+	hash.Write(s.seed[:])
+
+	var buf [8]byte
+	stuck := 0
+	for count := 0; count < jitterSamplesPerBlock; {
+		delta := s.measure()
+		if s.stuck(delta) {
+			stuck++
+			if stuck >= jitterMaxStuck {
+				return ErrNoJitter
+			}
+			continue
+		}
+
+		binary.LittleEndian.PutUint64(buf[:], uint64(delta))
+		hash.Write(buf[:])
+		stuck = 0
+		count++
+	}
+
+	digest := hash.Sum(nil)
+	s.seed = sha256.Sum256(append([]byte{0}, digest...))
+	s.block = sha256.Sum256(append([]byte{1}, digest...))
+	s.avail = len(s.block)
+	return nil
+}
+
+// measure returns the time spent by memory accesses and a hashing operation.
+func (s *CPUJitter) measure() int64 {
+	before := s.clock.Now()
+
+	for i := 0; i < jitterMemoryAccesses; i++ {
+		s.index = (s.index + jitterMemoryStep) % jitterMemorySize
+		s.memory[s.index]++
+	}
+
+	sum := sha256.Sum256(s.memory[:sha256.BlockSize])
+	s.memory[s.index] ^= sum[0]
+
+	return s.clock.Now().Sub(before).Nanoseconds()
+}
+
+// stuck determines whether specified time delta, its first or second
+// derivatives are zero, which means that no jitter was measured.
+func (s *CPUJitter) stuck(delta int64) bool {
+	diff := delta - s.lastDelta
+	diff2 := diff - s.lastDiff
+	s.lastDelta = delta
+	s.lastDiff = diff
+
+	return delta == 0 || diff == 0 || diff2 == 0
+}
+
+var _ io.ReadCloser = (*CPUJitter)(nil)
@@ -0,0 +1,158 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// A tickClock implements Clock advancing time on every call to Now by a
+// deterministic pseudo-random step. A zero seed advances time by a constant
+// step.
+type tickClock struct {
+	now  time.Time
+	seed uint32
+}
+
+func (c *tickClock) Now() time.Time {
+	step := time.Duration(1)
+	if c.seed != 0 {
+		c.seed = c.seed*1103515245 + 12345
+		step += time.Duration(c.seed>>16) % 1000
+	}
+
+	c.now = c.now.Add(step)
+	return c.now
+}
+
+func (c *tickClock) After(d time.Duration) <-chan time.Time {
+	c.now = c.now.Add(d)
+
+	result := make(chan time.Time, 1)
+	result <- c.now
+	return result
+}
+
+func TestCPUJitterUnpredictability(t *testing.T) {
+	rnd := NewCPUJitter()
+	defer rnd.Close()
+
+	dups, stddev := testUnpred(rnd)
+
+	if dups > MaximumDups {
+		t.Errorf(
+			"CPUJitter random generator: %d dups of %d",
+			int(TestingRounds*dups), TestingRounds)
+	}
+	if stddev < MinimumStandardDeviation {
+		t.Errorf(
+			"CPUJitter random generator: %.2f STDDEV (%.2f minimum)",
+			stddev, MinimumStandardDeviation)
+	}
+	t.Logf(
+		"CPUJitter random generator: %.2f%% dups/%.2f STDDEV",
+		dups*100, stddev)
+}
+
+func TestCPUJitterDeterministic(t *testing.T) {
+	read := func(seed uint32) []byte {
+		rnd := NewCPUJitterWithClock(&tickClock{seed: seed})
+		defer rnd.Close()
+
+		buff := make([]byte, 100)
+		if _, err := rnd.Read(buff); err != nil {
+			t.Fatalf("Error reading CPUJitter: %v", err)
+		}
+		return buff
+	}
+
+	first := read(1)
+	if !bytes.Equal(first, read(1)) {
+		t.Error("Same timings should generate same data")
+	}
+	if bytes.Equal(first, read(2)) {
+		t.Error("Different timings should generate different data")
+	}
+	if bytes.Equal(first[:32], first[32:64]) {
+		t.Error("Consecutive blocks should differ")
+	}
+}
+
+func TestCPUJitterStuck(t *testing.T) {
+	rnd := NewCPUJitterWithClock(&tickClock{})
+	defer rnd.Close()
+
+	n, err := rnd.Read(make([]byte, 1))
+	if err != ErrNoJitter {
+		t.Errorf("Should fail without timing jitter: got %v", err)
+	}
+	if n != 0 {
+		t.Errorf("Should not read data without timing jitter: read %d", n)
+	}
+}
+
+func TestCPUJitterClosed(t *testing.T) {
+	rnd := NewCPUJitterWithClock(&tickClock{seed: 1})
+	rnd.Read(make([]byte, 1))
+	rnd.Close()
+
+	if n, err := rnd.Read(make([]byte, 1)); err != ErrClosed || n != 0 {
+		t.Errorf("Read should return ErrClosed: got %d and %v", n, err)
+	}
+	for _, v := range rnd.block {
+		if v != 0 {
+			t.Fatal("Internal state should be erased")
+		}
+	}
+}
+
+func TestRandomAggrJitter(t *testing.T) {
+	rnd := NewRandomAggr().
+		AddJitter(1).
+		Build()
+	defer rnd.Close()
+
+	buf := make([]byte, 64)
+	n, err := rnd.Read(buf)
+	if err != nil {
+		t.Fatalf("Error reading from aggregation: %v", err)
+	}
+	if n != len(buf) {
+		t.Errorf("Should fill entire buffer: read %d bytes", n)
+	}
+}
+
+func BenchmarkCPUJitter(b *testing.B) {
+	rnd := NewCPUJitter()
+	buff := make([]byte, b.N)
+	b.ResetTimer()
+
+	n, err := rnd.Read(buff)
+	if err != nil {
+		b.Fatalf("Error reading CPUJitter: %v", err)
+	} else if n < len(buff) {
+		b.Fatalf("Error reading CPUJitter: should read %d bytes but read %d",
+			len(buff), n)
+	}
+
+	b.StopTimer()
+	rnd.Close()
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+)
+
+const (
+	// ctrKeySize defines the key length of CTR_DRBG using AES-256.
+	ctrKeySize = 32
+
+	// ctrSeedSize defines the seed length of CTR_DRBG using AES-256.
+	ctrSeedSize = ctrKeySize + aes.BlockSize
+)
+
+// A ctrDRBG implements CTR_DRBG mechanism using AES-256 and derivation
+// function.
+type ctrDRBG struct {
+	key   []byte
+	value []byte
+}
+
+func (d *ctrDRBG) instantiate(entropy, nonce, personalization []byte) {
+	d.key = make([]byte, ctrKeySize)
+	d.value = make([]byte, aes.BlockSize)
+	d.update(blockCipherDF(ctrSeedSize, entropy, nonce, personalization))
+}
+
+func (d *ctrDRBG) reseed(entropy, additional []byte) {
+	d.update(blockCipherDF(ctrSeedSize, entropy, additional))
+}
+
+func (d *ctrDRBG) generate(b, additional []byte) {
+	if len(additional) > 0 {
+		additional = blockCipherDF(ctrSeedSize, additional)
+		d.update(additional)
+	} else {
+		additional = make([]byte, ctrSeedSize)
+	}
+
+	block := d.cipher()
+	buf := make([]byte, aes.BlockSize)
+	for n := 0; n < len(b); {
+		addBigEndian(d.value, []byte{1})
+		block.Encrypt(buf, d.value)
+		n += copy(b[n:], buf)
+	}
+
+	d.update(additional)
+}
+
+func (d *ctrDRBG) erase() {
+	zero(d.key, d.value)
+}
+
+// update mixes provided data, which is seed length bytes, into key and value.
+func (d *ctrDRBG) update(provided []byte) {
+	block := d.cipher()
+	temp := make([]byte, ctrSeedSize)
+
+	for i := 0; i < len(temp); i += aes.BlockSize {
+		addBigEndian(d.value, []byte{1})
+		block.Encrypt(temp[i:], d.value)
+	}
+	for i := range temp {
+		temp[i] ^= provided[i]
+	}
+
+	d.key = temp[:ctrKeySize]
+	d.value = temp[ctrKeySize:]
+}
+
+// cipher returns an AES cipher using current key.
+func (d *ctrDRBG) cipher() cipher.Block {
+	block, _ := aes.NewCipher(d.key)
+	return block
+}
+
+// blockCipherDF implements Block_Cipher_df derivation function using AES-256,
+// which derives size bytes from specified input.
+func blockCipherDF(size int, input ...[]byte) []byte {
+	length := 0
+	for _, v := range input {
+		length += len(v)
+	}
+
+	s := make([]byte, 8, 8+length+aes.BlockSize)
+	binary.BigEndian.PutUint32(s, uint32(length))
+	binary.BigEndian.PutUint32(s[4:], uint32(size))
+	for _, v := range input {
+		s = append(s, v...)
+	}
+	s = append(s, 0x80)
+	for len(s)%aes.BlockSize != 0 {
+		s = append(s, 0)
+	}
+
+	key := make([]byte, ctrKeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	block, _ := aes.NewCipher(key)
+
+	temp := make([]byte, 0, ctrSeedSize)
+	iv := make([]byte, aes.BlockSize)
+	for i := uint32(0); len(temp) < ctrSeedSize; i++ {
+		binary.BigEndian.PutUint32(iv, i)
+		temp = append(temp, bcc(block, iv, s)...)
+	}
+
+	block, _ = aes.NewCipher(temp[:ctrKeySize])
+	x := temp[ctrKeySize:ctrSeedSize]
+	result := make([]byte, 0, size+aes.BlockSize)
+	for len(result) < size {
+		block.Encrypt(x, x)
+		result = append(result, x...)
+	}
+
+	return result[:size]
+}
+
+// bcc implements BCC function, which chains the encryption of specified
+// blocks.
+func bcc(block cipher.Block, data ...[]byte) []byte {
+	chaining := make([]byte, aes.BlockSize)
+
+	for _, v := range data {
+		for i := 0; i < len(v); i += aes.BlockSize {
+			for j := range chaining {
+				chaining[j] ^= v[i+j]
+			}
+			block.Encrypt(chaining, chaining)
+		}
+	}
+
+	return chaining
+}
+
+var _ drbgMechanism = (*ctrDRBG)(nil)
@@ -0,0 +1,152 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// ErrDeviceExhausted is returned by Device when a regular file was read to its
+// end, so its data is never served twice.
+var ErrDeviceExhausted = errors.New("device file is exhausted")
+
+// A Device provides random data read from a device or file, like /dev/urandom,
+// /dev/hwrng or a FIFO fed by an external generator. The device is opened on
+// first read, so a device which is absent fails its reads until it comes
+// online. A regular file is read only once.
+type Device struct {
+	path      string
+	file      *os.File
+	regular   bool
+	exhausted bool
+	closed    bool
+	mutex     *sync.Mutex
+	state     *sync.Mutex
+}
+
+// NewDevice creates a new instance of Device which reads from specified path.
+func NewDevice(path string) *Device {
+	return &Device{
+		path:  path,
+		mutex: &sync.Mutex{},
+		state: &sync.Mutex{},
+	}
+}
+
+// Close closes the device file. It does not wait for pending reads, which are
+// interrupted and return ErrClosed.
+func (s *Device) Close() error {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// Path returns the path of the device.
+func (s *Device) Path() string {
+	return s.path
+}
+
+// Read fills specified byte array with data read from the device, opening it
+// when needed. The device is opened without blocking, so a FIFO without
+// writers fails its reads until a writer is connected. The device is reopened
+// on next read after a failure, except a regular file which is never reopened
+// after its end is reached.
+//
+// Returns ErrDeviceExhausted when a regular file was read to its end, or
+// ErrClosed when current instance is closed.
+func (s *Device) Read(b []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file, err := s.open()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(file, b)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	if s.closed {
+		return n, ErrClosed
+	}
+	if err != nil {
+		s.file.Close()
+		s.file = nil
+		s.exhausted = s.regular &&
+			(err == io.EOF || err == io.ErrUnexpectedEOF)
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// open returns the device file, opening it when needed.
+func (s *Device) open() (*os.File, error) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+	if s.exhausted {
+		return nil, ErrDeviceExhausted
+	}
+	if s.file != nil {
+		return s.file, nil
+	}
+
+	// Opening a FIFO without writers blocks unless it is non-blocking, and a
+	// non-blocking file is read through runtime poller, which lets Close
+	// interrupt a pending read.
+	file, err := os.OpenFile(s.path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	s.file = file
+	s.regular = info.Mode().IsRegular()
+
+	return file, nil
+}
+
+var _ io.ReadCloser = (*Device)(nil)
@@ -0,0 +1,137 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+package crypt
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDeviceFIFO(t *testing.T) {
+	path, cleanup := tempDevice(t)
+	defer cleanup()
+
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Fatalf("Error creating FIFO: %v", err)
+	}
+
+	// Opening a FIFO for reading and writing does not wait for a reader.
+	writer, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Error opening FIFO: %v", err)
+	}
+	defer writer.Close()
+
+	data := bytes.Repeat([]byte{7}, 1000)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatalf("Error writing FIFO: %v", err)
+	}
+
+	rnd := NewDevice(path)
+	defer rnd.Close()
+
+	buf := make([]byte, len(data))
+	if n, err := rnd.Read(buf); err != nil || n != len(buf) {
+		t.Fatalf("Should read from FIFO: read %d bytes: %v", n, err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Error("Unexpected data read from FIFO")
+	}
+}
+
+func TestDeviceFIFONoWriter(t *testing.T) {
+	path, cleanup := tempDevice(t)
+	defer cleanup()
+
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Fatalf("Error creating FIFO: %v", err)
+	}
+
+	rnd := NewRandomAggr().
+		AddDevice(path, 1).
+		Add(InfiniteSource(2), 1).
+		Build()
+
+	done := make(chan error)
+	go func() {
+		buf := make([]byte, 10)
+		n, err := rnd.Read(buf)
+		if err == nil && n == len(buf) {
+			testValues(buf, 2, t)
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Should redistribute FIFO without writers: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Read should not block on FIFO without writers")
+	}
+
+	rnd.Close()
+}
+
+func TestDeviceFIFOCloseWhileReading(t *testing.T) {
+	path, cleanup := tempDevice(t)
+	defer cleanup()
+
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Fatalf("Error creating FIFO: %v", err)
+	}
+
+	// A writer which never writes keeps the read pending.
+	writer, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Error opening FIFO: %v", err)
+	}
+	defer writer.Close()
+
+	rnd := NewDevice(path)
+	done := make(chan error)
+	go func() {
+		_, err := rnd.Read(make([]byte, 10))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan bool)
+	go func() {
+		rnd.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close should not wait for pending read")
+	}
+	select {
+	case err := <-done:
+		if err != ErrClosed {
+			t.Errorf("Pending read should return ErrClosed: got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close should interrupt pending read")
+	}
+}
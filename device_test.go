@@ -0,0 +1,159 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+package crypt
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tempDevice returns the path of a temporary file standing in for a device.
+func tempDevice(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "crypt")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %v", err)
+	}
+
+	return filepath.Join(dir, "hwrng"), func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDeviceRead(t *testing.T) {
+	path, cleanup := tempDevice(t)
+	defer cleanup()
+
+	data := []byte("0123456789")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Error writing device file: %v", err)
+	}
+
+	rnd := NewDevice(path)
+	defer rnd.Close()
+
+	buf := make([]byte, 6)
+	if n, err := rnd.Read(buf); err != nil || n != 6 {
+		t.Fatalf("Should read 6 bytes: read %d bytes: %v", n, err)
+	}
+	if !bytes.Equal(buf, data[:6]) {
+		t.Errorf("Unexpected data: %q", buf)
+	}
+
+	n, err := rnd.Read(buf)
+	if err != io.EOF || n != 4 {
+		t.Errorf("Should read 4 bytes until EOF: read %d bytes: %v", n, err)
+	}
+
+	if n, err := rnd.Read(buf); err != ErrDeviceExhausted || n != 0 {
+		t.Errorf("Should not read exhausted file again: read %d bytes: %v",
+			n, err)
+	}
+}
+
+func TestDeviceAbsent(t *testing.T) {
+	path, cleanup := tempDevice(t)
+	defer cleanup()
+
+	rnd := NewDevice(path)
+	defer rnd.Close()
+
+	buf := make([]byte, 4)
+	if _, err := rnd.Read(buf); !os.IsNotExist(err) {
+		t.Errorf("Should fail while device is absent: got %v", err)
+	}
+
+	ioutil.WriteFile(path, []byte("data"), 0600)
+	if _, err := rnd.Read(buf); err != nil || string(buf) != "data" {
+		t.Errorf("Should read device once it comes online: %q: %v", buf, err)
+	}
+}
+
+func TestDeviceClosed(t *testing.T) {
+	rnd := NewDevice(os.DevNull)
+	rnd.Close()
+	rnd.Close()
+
+	if _, err := rnd.Read(make([]byte, 1)); err != ErrClosed {
+		t.Errorf("Read should return ErrClosed: got %v", err)
+	}
+}
+
+func TestRandomAggrAddDevice(t *testing.T) {
+	path, cleanup := tempDevice(t)
+	defer cleanup()
+
+	rnd := NewRandomAggr().
+		AddDevice(path, 1).
+		Add(InfiniteSource(2), 1).
+		Build()
+	defer rnd.Close()
+
+	buf := make([]byte, 100)
+	if n, err := rnd.Read(buf); err != nil || n != len(buf) {
+		t.Fatalf("Should redistribute absent device: read %d bytes: %v",
+			n, err)
+	}
+	testValues(buf, 2, t)
+
+	sources := rnd.Sources()
+	if sources[0].Name != path || sources[0].Errors != 1 {
+		t.Errorf("Device should be named by its path: %v", sources[0])
+	}
+
+	ioutil.WriteFile(path, bytes.Repeat([]byte{1}, 50), 0600)
+	if n, err := rnd.Read(buf); err != nil || n != len(buf) {
+		t.Fatalf("Should fill entire buffer: read %d bytes: %v", n, err)
+	}
+	testValues(buf[:50], 1, t)
+	testValues(buf[50:], 2, t)
+}
+
+func TestRandomAggrAddDeviceExhausted(t *testing.T) {
+	path, cleanup := tempDevice(t)
+	defer cleanup()
+
+	data := []byte("ABCDEFGH")
+	ioutil.WriteFile(path, data, 0600)
+
+	rnd := NewRandomAggr().
+		AddDevice(path, 1).
+		Add(InfiniteSource(2), 1).
+		Build()
+	defer rnd.Close()
+
+	served := make([]byte, 0, len(data))
+	buf := make([]byte, 8)
+	for i := 0; i < 5; i++ {
+		if n, err := rnd.Read(buf); err != nil || n != len(buf) {
+			t.Fatalf("Should fill entire buffer: read %d bytes: %v", n, err)
+		}
+		for _, v := range buf {
+			if v != 2 {
+				served = append(served, v)
+			}
+		}
+	}
+
+	if !bytes.Equal(served, data) {
+		t.Errorf("File data should be served exactly once: got %q", served)
+	}
+}
@@ -17,22 +17,92 @@
 /*
 Package crypt provides some cryptographic operations.
 
-RandomAggr
+# BufferedAggr
+
+A BufferedAggr keeps pre-generated random data from a RandomAggr, refilled in
+background whenever its fill level drops below a low-water mark, to hide latency
+of slow sources like SSTDEG.
+
+# ChaCha20
+
+A ChaCha20 provides a fast pseudo-random generator with fast key erasure, which
+is periodically reseeded from another source like a RandomAggr. It can be used
+by Salter to avoid reading slow sources for every token.
+
+# CPUJitter
+
+A CPUJitter provides a pseudo-random generator based on CPU timing jitter of
+memory accesses and hashing operations. It implements io.ReadCloser interface.
+
+# Device
+
+A Device reads random data from a device or file, like /dev/hwrng or a FIFO
+fed by an external generator. It is opened on first read, so an absent device
+fails its reads until it comes online.
+
+# DRBG
+
+A DRBG provides a deterministic random bit generator as specified by NIST SP
+800-90A, using HMAC_DRBG, Hash_DRBG or CTR_DRBG mechanism. It is seeded from
+any io.Reader, like a RandomAggr.
+
+# Fortuna
+
+A Fortuna provides a cryptographically secure pseudo-random generator which
+gathers events from other sources, as designed by Ferguson and Schneier. It
+implements io.ReadCloser interface.
+
+# Getrandom
+
+A Getrandom reads random data by Linux getrandom(2) system call, supporting
+GRND_RANDOM and GRND_NONBLOCK flags.
+
+# RandSource
+
+A RandSource adapts any of these readers as a source of math/rand and
+math/rand/v2 packages, like rand.New(crypt.NewRandSource(aggr)).
+
+# RandomAggr
 
 A RandomAggr allows to aggregate random data sources to fill a buffer. Each
-source has weight to control the percentage from total to be read.
+source has weight to control the percentage from total to be read, which is
+apportioned exactly by largest remainder method. Portions can be interleaved in
+round-robin chunks instead of contiguous blocks.
+Alternatively, sources can be combined by XOR or hashing, so every output byte
+depends on all sources.
+Sources which fail or cannot deliver enough data are handled by a failure
+policy, which redistributes their shortfall, fails closed or fails over to a
+backup source. A RandomAggr is safe for concurrent use and can read its sources
+in parallel.
+Sources can be named, so errors and metrics identify them, and their live
+statistics are available from Sources method.
+Sources of a live RandomAggr can be replaced by ReplaceSources, which lets
+reads in flight complete against previous sources and closes dropped ones.
+
+A RandomAggrConfig declares sources and options of a RandomAggr, parsed from a
+spec like "sys:8,sstdeg:1,file:/dev/hwrng:2", JSON or YAML. Custom source kinds
+are registered by RegisterSourceKind.
 
 It implements the io.ReadCloser interface to allow to close sources if needed.
 
-Salter
+# Recorder and Replay
+
+A Recorder tees random data read from any source to a file, which a Replay plays
+back deterministically, failing once recorded data is exhausted. They allow to
+reproduce exactly failures involving Salter, RandomAggr or SSTDEG.
+
+# Salter
 
 A Salter provides a random data generator to password salt and unique session
 IDs. Every token generated is used to salt next token to increase
 unpredictability of generated data.
 
-SSTDEG
+# SSTDEG
 
 A SSTDEG provides a pseudo-random generator based on syscall time deltas of
 Sleep calls. It implements io.Reader interface.
+
+A process-wide SSTDEG instance can be shared by calling AcquireSSTDEG, its
+background routine is stopped when last reference is closed.
 */
 package crypt
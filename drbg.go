@@ -0,0 +1,248 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"io"
+	"sync"
+)
+
+const (
+	// drbgEntropySize defines how many bytes of entropy input are read to
+	// provide a security strength of 256 bits.
+	drbgEntropySize = 32
+
+	// drbgNonceSize defines how many bytes of nonce are read on
+	// instantiation.
+	drbgNonceSize = 16
+
+	// drbgMaxRequest defines the maximum bytes generated by a single request
+	// to a DRBG mechanism.
+	drbgMaxRequest = 1 << 16
+
+	// DefaultReseedInterval defines how many requests a DRBG serves before
+	// it is reseeded.
+	DefaultReseedInterval = 1 << 20
+)
+
+// A drbgMechanism implements a DRBG mechanism from NIST SP 800-90A.
+type drbgMechanism interface {
+	// instantiate initializes internal state from entropy input, nonce and
+	// personalization string.
+	instantiate(entropy, nonce, personalization []byte)
+
+	// reseed mixes entropy input and additional input into internal state.
+	reseed(entropy, additional []byte)
+
+	// generate fills specified byte array, up to drbgMaxRequest, and
+	// updates internal state with additional input.
+	generate(b, additional []byte)
+
+	// erase zeroes internal state.
+	erase()
+}
+
+// A DRBG provides a deterministic random bit generator as specified by NIST
+// SP 800-90A, which is seeded from an entropy input source like RandomAggr.
+type DRBG struct {
+	mechanism            drbgMechanism
+	entropy              io.Reader
+	predictionResistance bool
+	reseedInterval       uint64
+	counter              uint64
+	closed               bool
+	mutex                *sync.Mutex
+}
+
+// NewHMACDRBG creates a new instance of HMAC_DRBG using SHA-256, which reads
+// entropy input from specified reader and uses an optional personalization
+// string.
+func NewHMACDRBG(entropy io.Reader, personalization []byte) (*DRBG, error) {
+	return newDRBG(&hmacDRBG{}, entropy, personalization)
+}
+
+// NewHashDRBG creates a new instance of Hash_DRBG using SHA-256, which reads
+// entropy input from specified reader and uses an optional personalization
+// string.
+func NewHashDRBG(entropy io.Reader, personalization []byte) (*DRBG, error) {
+	return newDRBG(&hashDRBG{}, entropy, personalization)
+}
+
+// NewCTRDRBG creates a new instance of CTR_DRBG using AES-256 and derivation
+// function, which reads entropy input from specified reader and uses an
+// optional personalization string.
+func NewCTRDRBG(entropy io.Reader, personalization []byte) (*DRBG, error) {
+	return newDRBG(&ctrDRBG{}, entropy, personalization)
+}
+
+// newDRBG instantiates specified DRBG mechanism.
+func newDRBG(
+	mechanism drbgMechanism,
+	entropy io.Reader,
+	personalization []byte,
+) (*DRBG, error) {
+	input := make([]byte, drbgEntropySize+drbgNonceSize)
+	if _, err := io.ReadFull(entropy, input); err != nil {
+		return nil, err
+	}
+
+	mechanism.instantiate(
+		input[:drbgEntropySize], input[drbgEntropySize:], personalization)
+
+	return &DRBG{
+		mechanism:      mechanism,
+		entropy:        entropy,
+		reseedInterval: DefaultReseedInterval,
+		counter:        1,
+		mutex:          &sync.Mutex{},
+	}, nil
+}
+
+// Close erases internal state. It does not close entropy input source.
+func (s *DRBG) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	s.mechanism.erase()
+	return nil
+}
+
+// Generate fills specified byte array with random data and mixes optional
+// additional input into internal state. The DRBG is reseeded before
+// generating data when prediction resistance is enabled or when reseed
+// interval is reached.
+func (s *DRBG) Generate(b, additional []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > drbgMaxRequest {
+			chunk = chunk[:drbgMaxRequest]
+		}
+
+		if s.predictionResistance || s.counter > s.reseedInterval {
+			if err := s.reseed(additional); err != nil {
+				return err
+			}
+			additional = nil
+		}
+
+		s.mechanism.generate(chunk, additional)
+		s.counter++
+		additional = nil
+		b = b[len(chunk):]
+	}
+
+	return nil
+}
+
+// Read fills specified byte array with random data.
+func (s *DRBG) Read(b []byte) (int, error) {
+	if err := s.Generate(b, nil); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// Reseed mixes new entropy input and optional additional input into internal
+// state.
+func (s *DRBG) Reseed(additional []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	return s.reseed(additional)
+}
+
+// ReseedCounter returns how many requests were served since last reseed, plus
+// one.
+func (s *DRBG) ReseedCounter() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.counter
+}
+
+// SetPredictionResistance defines whether the DRBG is reseeded before every
+// request.
+func (s *DRBG) SetPredictionResistance(enabled bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.predictionResistance = enabled
+}
+
+// SetReseedInterval defines how many requests are served before the DRBG is
+// reseeded.
+func (s *DRBG) SetReseedInterval(requests uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if requests < 1 {
+		requests = 1
+	}
+	s.reseedInterval = requests
+}
+
+// reseed reads entropy input and reseeds DRBG mechanism.
+func (s *DRBG) reseed(additional []byte) error {
+	entropy := make([]byte, drbgEntropySize)
+	if _, err := io.ReadFull(s.entropy, entropy); err != nil {
+		return err
+	}
+
+	s.mechanism.reseed(entropy, additional)
+	s.counter = 1
+	return nil
+}
+
+// addBigEndian adds src into dst modulo 2^(8*len(dst)), both as big-endian
+// integers.
+func addBigEndian(dst, src []byte) {
+	carry := 0
+	for i, j := len(dst)-1, len(src)-1; i >= 0; i, j = i-1, j-1 {
+		sum := int(dst[i]) + carry
+		if j >= 0 {
+			sum += int(src[j])
+		}
+		dst[i] = byte(sum)
+		carry = sum >> 8
+	}
+}
+
+// zero erases specified byte arrays.
+func zero(list ...[]byte) {
+	for _, b := range list {
+		for i := range b {
+			b[i] = 0
+		}
+	}
+}
+
+var _ io.ReadCloser = (*DRBG)(nil)
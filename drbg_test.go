@@ -0,0 +1,492 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+)
+
+// A drbgTest defines a known-answer test following CAVP procedure, which
+// instantiates the DRBG, optionally reseeds it, generates data twice and checks
+// the second output. When prediction resistance is tested, the DRBG is
+// reseeded with additional input before each request.
+type drbgTest struct {
+	name               string
+	mechanism          func() drbgMechanism
+	constructor        func(io.Reader, []byte) (*DRBG, error)
+	entropy            string
+	nonce              string
+	personalization    string
+	reseedEntropy      string
+	additionalReseed   string
+	predictionEntropy1 string
+	predictionEntropy2 string
+	additional1        string
+	additional2        string
+	expected           string
+}
+
+func newHMACMechanism() drbgMechanism { return &hmacDRBG{} }
+func newHashMechanism() drbgMechanism { return &hashDRBG{} }
+func newCTRMechanism() drbgMechanism  { return &ctrDRBG{} }
+
+// Tests from NIST CAVP drbgtestvectors.zip (CAVS 14.3): HMAC_DRBG.rsp and
+// Hash_DRBG.rsp [SHA-256], CTR_DRBG.rsp [AES-256 use df], COUNT = 0 of each
+// PersonalizationStringLen and AdditionalInputLen of 0 and 256.
+var drbgTests = []drbgTest{
+	// drbgvectors_no_reseed: never reseeded.
+	{
+		name:        "HMAC_DRBG no reseed 0/0",
+		mechanism:   newHMACMechanism,
+		constructor: NewHMACDRBG,
+		entropy:     "ca851911349384bffe89de1cbdc46e6831e44d34a4fb935ee285dd14b71a7488",
+		nonce:       "659ba96c601dc69fc902940805ec0ca8",
+		expected: "e528e9abf2dece54d47c7e75e5fe302149f817ea9fb4bee6f4199697d04d5b89" +
+			"d54fbb978a15b5c443c9ec21036d2460b6f73ebad0dc2aba6e624abf07745bc1" +
+			"07694bb7547bb0995f70de25d6b29e2d3011bb19d27676c07162c8b5ccde0668" +
+			"961df86803482cb37ed6d5c0bb8d50cf1f50d476aa0458bdaba806f48be9dcb8",
+	},
+	{
+		name:            "HMAC_DRBG no reseed 256/256",
+		mechanism:       newHMACMechanism,
+		constructor:     NewHMACDRBG,
+		entropy:         "5d3286bc53a258a53ba781e2c4dcd79a790e43bbe0e89fb3eed39086be34174b",
+		nonce:           "c5422294b7318952ace7055ab7570abf",
+		personalization: "2dba094d008e150d51c4135bb2f03dcde9cbf3468a12908a1b025c120c985b9d",
+		additional1:     "793a7ef8f6f0482beac542bb785c10f8b7b406a4de92667ab168ecc2cf7573c6",
+		additional2:     "2238cdb4e23d629fe0c2a83dd8d5144ce1a6229ef41dabe2a99ff722e510b530",
+		expected: "d04678198ae7e1aeb435b45291458ffde0891560748b43330eaf866b5a6385e7" +
+			"4c6fa5a5a44bdb284d436e98d244018d6acedcdfa2e9f499d8089e4db86ae89a" +
+			"6ab2d19cb705e2f048f97fb597f04106a1fa6a1416ad3d859118e079a0c319eb" +
+			"95686f4cbcce3b5101c7a0b010ef029c4ef6d06cdfac97efb9773891688c37cf",
+	},
+	{
+		name:        "Hash_DRBG no reseed 0/0",
+		mechanism:   newHashMechanism,
+		constructor: NewHashDRBG,
+		entropy:     "a65ad0f345db4e0effe875c3a2e71f42c7129d620ff5c119a9ef55f05185e0fb",
+		nonce:       "8581f9317517276e06e9607ddbcbcc2e",
+		expected: "d3e160c35b99f340b2628264d1751060e0045da383ff57a57d73a673d2b8d80d" +
+			"aaf6a6c35a91bb4579d73fd0c8fed111b0391306828adfed528f018121b3febd" +
+			"c343e797b87dbb63db1333ded9d1ece177cfa6b71fe8ab1da46624ed6415e51c" +
+			"cde2c7ca86e283990eeaeb91120415528b2295910281b02dd431f4c9f70427df",
+	},
+	{
+		name:            "Hash_DRBG no reseed 256/256",
+		mechanism:       newHashMechanism,
+		constructor:     NewHashDRBG,
+		entropy:         "68c43a008fe46a823d260a9d7fa388fb9e401f0197e7e758a744b4babb3f4651",
+		nonce:           "eb6825777856331884aaf3751b3e4006",
+		personalization: "23ce0d32cbf2d26467f0d62acff1a3acbaa6d2746dc3ee7aa9d32c880788afc8",
+		additional1:     "a31b9f13b58d4fa2f8d8ac42b62a207ff647339a146bd8b268b33d4aff57adbd",
+		additional2:     "d34fc6504eca4b568193c75357b0d3821a48c77ff80d6dbd21c6cf045ff489cf",
+		expected: "abb4ecbacd4e8fa943c7221aed433861c3b203232657ec4c417d021f905d911d" +
+			"b1058ff1e11e272232482ec96bae7cb4efc135502dbe41724077077f6de79b71" +
+			"3670c385d04644e1281c3e582e0016255abbe5f8c06d0de57160559f0c08f7fb" +
+			"5be3563c649966190f8d3261364447537de2c7371c6e8c308933d27145bf90ab",
+	},
+	{
+		name:        "CTR_DRBG no reseed 0/0",
+		mechanism:   newCTRMechanism,
+		constructor: NewCTRDRBG,
+		entropy:     "36401940fa8b1fba91a1661f211d78a0b9389a74e5bccfece8d766af1a6d3b14",
+		nonce:       "496f25b0f1301b4f501be30380a137eb",
+		expected: "5862eb38bd558dd978a696e6df164782ddd887e7e9a6c9f3f1fbafb78941b535" +
+			"a64912dfd224c6dc7454e5250b3d97165e16260c2faf1cc7735cb75fb4f07e1d",
+	},
+	{
+		name:            "CTR_DRBG no reseed 256/256",
+		mechanism:       newCTRMechanism,
+		constructor:     NewCTRDRBG,
+		entropy:         "87b56e964eba227154724bb9484b812d3e2c0c43b3d17f6098d9526e16e6d0ef",
+		nonce:           "9bea6a7ff2358df142e6c23e2157fb83",
+		personalization: "9860b432edd58d1ccbfeecbce99ffaee7d935a614860d4e965bd67041403096b",
+		additional1:     "99a5cc87924e8ea65a596f81fd17d63f5b4542fe6e8e1511b5d35c835dfadb0b",
+		additional2:     "9a8dec54734a34582a2332f3452e82313524c3e0dfb485faeac6ca5fc0ff504d",
+		expected: "dbc6a2330b19b5cddd8cd6392ec1fb508678c805e87d1aca07ac265007632503" +
+			"044a00610c79d98375afa7ab4cca1a90989cbfe7c674af5d823ced11c47e9af6",
+	},
+	// drbgvectors_pr_false: reseeded once after instantiation.
+	{
+		name:          "HMAC_DRBG pr false 0/0",
+		mechanism:     newHMACMechanism,
+		constructor:   NewHMACDRBG,
+		entropy:       "06032cd5eed33f39265f49ecb142c511da9aff2af71203bffaf34a9ca5bd9c0d",
+		nonce:         "0e66f71edc43e42a45ad3c6fc6cdc4df",
+		reseedEntropy: "01920a4e669ed3a85ae8a33b35a74ad7fb2a6bb4cf395ce00334a9c9a5a5d552",
+		expected: "76fc79fe9b50beccc991a11b5635783a83536add03c157fb30645e611c2898bb" +
+			"2b1bc215000209208cd506cb28da2a51bdb03826aaf2bd2335d576d519160842" +
+			"e7158ad0949d1a9ec3e66ea1b1a064b005de914eac2e9d4f2d72a8616a802254" +
+			"22918250ff66a41bd2f864a6a38cc5b6499dc43f7f2bd09e1e0f8f5885935124",
+	},
+	{
+		name:             "HMAC_DRBG pr false 256/256",
+		mechanism:        newHMACMechanism,
+		constructor:      NewHMACDRBG,
+		entropy:          "cdb0d9117cc6dbc9ef9dcb06a97579841d72dc18b2d46a1cb61e314012bdf416",
+		nonce:            "d0c0d01d156016d0eb6b7e9c7c3c8da8",
+		personalization:  "6f0fb9eab3f9ea7ab0a719bfa879bf0aaed683307fda0c6d73ce018b6e34faaa",
+		reseedEntropy:    "8ec6f7d5a8e2e88f43986f70b86e050d07c84b931bcf18e601c5a3eee3064c82",
+		additionalReseed: "1ab4ca9014fa98a55938316de8ba5a68c629b0741bdd058c4d70c91cda5099b3",
+		additional1:      "16e2d0721b58d839a122852abd3bf2c942a31c84d82fca74211871880d7162ff",
+		additional2:      "53686f042a7b087d5d2eca0d2a96de131f275ed7151189f7ca52deaa78b79fb2",
+		expected: "dda04a2ca7b8147af1548f5d086591ca4fd951a345ce52b3cd49d47e84aa31a1" +
+			"83e31fbc42a1ff1d95afec7143c8008c97bc2a9c091df0a763848391f68cb4a3" +
+			"66ad89857ac725a53b303ddea767be8dc5f605b1b95f6d24c9f06be65a973a08" +
+			"9320b3cc42569dcfd4b92b62a993785b0301b3fc452445656fce22664827b88f",
+	},
+	{
+		name:          "Hash_DRBG pr false 0/0",
+		mechanism:     newHashMechanism,
+		constructor:   NewHashDRBG,
+		entropy:       "63363377e41e86468deb0ab4a8ed683f6a134e47e014c700454e81e95358a569",
+		nonce:         "808aa38f2a72a62359915a9f8a04ca68",
+		reseedEntropy: "e62b8a8ee8f141b6980566e3bfe3c04903dad4ac2cdf9f2280010a6739bc83d3",
+		expected: "04eec63bb231df2c630a1afbe724949d005a587851e1aa795e477347c8b05662" +
+			"1c18bddcdd8d99fc5fc2b92053d8cfacfb0bb8831205fad1ddd6c071318a6018" +
+			"f03b73f5ede4d4d071f9de03fd7aea105d9299b8af99aa075bdb4db9aa28c18d" +
+			"174b56ee2a014d098896ff2282c955a81969e069fa8ce007a180183a07dfae17",
+	},
+	{
+		name:             "Hash_DRBG pr false 256/256",
+		mechanism:        newHashMechanism,
+		constructor:      NewHashDRBG,
+		entropy:          "6c623aea73bc8a59e28c6cd9c7c7ec8ca2e75190bd5dcae5978cf0c199c23f4f",
+		nonce:            "e55db067a0ed537e66886b7cda02f772",
+		personalization:  "1e59d798810083d1ff848e90b25c9927e3dfb55a0888b0339566a9f9ca7542dc",
+		reseedEntropy:    "9ab40164744c7d00c78b4196f6f917ec33d70030a0812cd4606c5a25387568a9",
+		additionalReseed: "4e8bead7cbba7a7bc9ae1e1617222c4139661347599950e7225d1e2faa5d57f5",
+		additional1:      "dcb22a5d9f149858636f3ede2253e419816fb7b1103194451ed6a573a8fe6271",
+		additional2:      "8f9d5c78cdabc32e71ac3b3c49239caddf96053250f4fd92056efbd0be487d36",
+		expected: "6e98a3b1f686f6ffa79355c9d8a5ab7f93312159d52659a2298315f10007c71a" +
+			"dabc0b5ccb4164c0949fbdb221b43acdb62bed3099596f2d7bd5d0048173dd23" +
+			"60a543b234ab61a441ddb9299af84ca45c6e618fd521366dbf509d4ec06174da" +
+			"924361d642b107e5564ac1b32340dd2f3158bf4c00bcb4dcf12c6d67af4b74ee",
+	},
+	{
+		name:          "CTR_DRBG pr false 0/0",
+		mechanism:     newCTRMechanism,
+		constructor:   NewCTRDRBG,
+		entropy:       "2d4c9f46b981c6a0b2b5d8c69391e569ff13851437ebc0fc00d616340252fed5",
+		nonce:         "0bf814b411f65ec4866be1abb59d3c32",
+		reseedEntropy: "93500fae4fa32b86033b7a7bac9d37e710dcc67ca266bc8607d665937766d207",
+		expected: "322dd28670e75c0ea638f3cb68d6a9d6e50ddfd052b772a7b1d78263a7b8978b" +
+			"6740c2b65a9550c3a76325866fa97e16d74006bc96f26249b9f0a90d076f08e5",
+	},
+	{
+		name:             "CTR_DRBG pr false 256/256",
+		mechanism:        newCTRMechanism,
+		constructor:      NewCTRDRBG,
+		entropy:          "174b46250051a9e3d80c56ae7163dafe7e54481a56cafd3b8625f99bbb29c442",
+		nonce:            "98ffd99c466e0e94a45da7e0e82dbc6b",
+		personalization:  "7095268e99938b3e042734b9176c9aa051f00a5f8d2a89ada214b89beef18ebf",
+		reseedEntropy:    "e88be1967c5503f65d23867bbc891bd679db03b4878663f6c877592df25f0d9a",
+		additionalReseed: "cdf6ad549e45b6aa5cd67d024931c33cd133d52d5ae500c3015020beb30da063",
+		additional1:      "c7228e90c62f896a09e11684530102f926ec90a3255f6c21b857883c75800143",
+		additional2:      "76a94f224178fe4cbf9e2b8acc53c9dc3e50bb613aac8936601453cda3293b17",
+		expected: "1a6d8dbd642076d13916e5e23038b60b26061f13dd4e006277e0268698ffb2c8" +
+			"7e453bae1251631ac90c701a9849d933995e8b0221fe9aca1985c546c2079027",
+	},
+	// drbgvectors_pr_true: prediction resistance enabled.
+	{
+		name:               "HMAC_DRBG pr true 0/0",
+		mechanism:          newHMACMechanism,
+		constructor:        NewHMACDRBG,
+		entropy:            "9969e54b4703ff31785b879a7e5c0eae0d3e309559e9fe96b0676d49d591ea4d",
+		nonce:              "07d20d46d064757d3023cac2376127ab",
+		predictionEntropy1: "c60f2999100f738c10f74792676a3fc4a262d13721798046e29a295181569f54",
+		predictionEntropy2: "c11d4524c9071bd3096015fcf7bc24a607f22fa065c937658a2a77a8699089f4",
+		expected: "abc015856094803a938dffd20da94843870ef935b82cfec17706b8f551b83850" +
+			"44235dd44b599f94b39be78dd476e0cf11309c995a7334e0a78b37bc95862350" +
+			"86fa3b637ba91cf8fb65efa22a589c137531aa7b2d4e2607aac27292b01c698e" +
+			"6e01ae679eb87c01a89c7422d4372d6d754ababb4bf896fcb1cd09d692d0283f",
+	},
+	{
+		name:               "HMAC_DRBG pr true 256/256",
+		mechanism:          newHMACMechanism,
+		constructor:        NewHMACDRBG,
+		entropy:            "4294671d493dc085b5184607d7de2ff2b6aceb734a1b026f6cfee7c5a90f03da",
+		nonce:              "d071544e599235d5eb38b64b551d2a6e",
+		personalization:    "63bc769ae1d95a98bde870e4db7776297041d37c8a5c688d4e024b78d83f4d78",
+		predictionEntropy1: "db9b4790b62336fbb9a684b82947065393eeef8f57bd2477141ad17e776dac34",
+		predictionEntropy2: "4a9abe80f6f522f29878bedf8245b27940a76471006fb4a4110beb4decb6c341",
+		additional1:        "28848becd3f47696f124f4b14853a456156f69be583a7d4682cff8d44b39e1d3",
+		additional2:        "8bfce0b7132661c3cd78175d83926f643e36f7608eec2c5dac3ddcbacc8c2182",
+		expected: "e580dc969194b2b18a97478aef9d1a72390aff14562747bf080d741527a6655c" +
+			"e7fc135325b457483a9f9c70f91165a811cf4524b50d51199a0df3bd60d12aba" +
+			"c27d0bf6618e6b114e05420352e23f3603dfe8a225dc19b3d1fff1dc245dc6b1" +
+			"df24c741744bec3f9437dbbf222df84881a457a589e7815ef132f686b760f012",
+	},
+	{
+		name:               "Hash_DRBG pr true 0/0",
+		mechanism:          newHashMechanism,
+		constructor:        NewHashDRBG,
+		entropy:            "4a4e743f877ea6e94e545a56ccb5a1f99efc7eb1e8191929152a56d41dc92425",
+		nonce:              "33b7ab9356acf7da03d3d6773b61f8d9",
+		predictionEntropy1: "2e148f9269d00a162e897a91f3aca46fed1e2adbab4f848218f288650dab8b1e",
+		predictionEntropy2: "2566475353ec8ced47d03b76fca779d0668c95136cf7866259d9e3b7e0d1f74f",
+		expected: "924b74d6ba3d56dffc0de00711e3010ccbdb730718743f0cc0631931434b0ffc" +
+			"4ad6d5ef96fd81f16e51a10206f674987d1b52f0cf15d294c98bc4a877f4716c" +
+			"0ff2c2484d3f057eafd09154874aa2a213a2641da1a3d7be6988616b6bb483cd" +
+			"9213ed750858ce85811b8f708dcfd607b383eda845c87f60084db7a0500643b1",
+	},
+	{
+		name:               "Hash_DRBG pr true 256/256",
+		mechanism:          newHashMechanism,
+		constructor:        NewHashDRBG,
+		entropy:            "29e1a42709b7e84dbe50788fbad8cb609c127eec3262636a513fd9059fb8bae4",
+		nonce:              "f3a521f28dffbd97574c405b69b636ad",
+		personalization:    "c99a1147d8db401f4fcf763867296758e26404a30a4a9fa496a717f21f5d749b",
+		predictionEntropy1: "d5266c01e10d72dd7e8a3bf717cccb8f643ca233314e3e74f106f46b090e5c73",
+		predictionEntropy2: "c7e5f03d26bdf9553338e64ba64ce5b5751b04f9c69992221495f2227b9799d0",
+		additional1:        "17254ea392aead0dc94992d867813497d3fd6dc7669667150afe6c28a2b89946",
+		additional2:        "157dde59ceb2c662c8665fbe623ec75873fd0c5ccce79a9f5b7098ec8ed77b67",
+		expected: "e304de9ffd885cf917ead78f05939b8cf54709fc2d0c799a98b5434863372044" +
+			"77b1060bceae2a22f7ff42b6cb4b4bc0610ae2b67558a7c54b65e45bb9f1a869" +
+			"81b74705b48cdbf7d8decf87868267bd948e9394aa4357f8dbbf30612a0eb5b1" +
+			"31884c220e442d36778e8d74091d8a27c070fe690469e07f3aabeef7c629bfab",
+	},
+	{
+		name:               "CTR_DRBG pr true 0/0",
+		mechanism:          newCTRMechanism,
+		constructor:        NewCTRDRBG,
+		entropy:            "16a1f035388cd8d956026e3b0117cb524dd3eb563f9a7720bb7dcb0fc6fbe743",
+		nonce:              "a2d015f22d854e29de278d910c573de5",
+		predictionEntropy1: "cf140bcd4d7130e7e3ea14046c56442b57c43b34ad219553e7105c18f6e561af",
+		predictionEntropy2: "e27c9f0be60d82d6cc474efb7fc737b16a6895d9a3a45b971d19b743c1a4ac8f",
+		expected: "b4e8395bcb7503410a94633f70e9904a5b30e62c35bc6dd2a03496c4a49932e1" +
+			"84fbffdbcf1de1c72c50d36dc2ae8f04f40f96aae159c3fb816ca16df99b6c3e",
+	},
+	{
+		name:               "CTR_DRBG pr true 256/256",
+		mechanism:          newCTRMechanism,
+		constructor:        NewCTRDRBG,
+		entropy:            "534346a3e0baa65d7a51871b6d633a6f1efa9ff55dfde3212c95029adf2387d9",
+		nonce:              "0cbe99821509975d824fd826c47d2abc",
+		personalization:    "020d7340569e68d992e6e8ddfbead9f993c74d971e4339fe91074f87e9d7d777",
+		predictionEntropy1: "286091709d2e91bfef6654b8449d5e5564b6c2fe1f3e4e6e59fe776461bfb0dc",
+		predictionEntropy2: "e68e127b2e5b9d6539c26f7e78bec13314e37febdb7105d1d65f0bde23c87d38",
+		additional1:        "f41040eff2a7010287c2a76d7867a66e61299be1e1247a6b22a64b829ada8624",
+		additional2:        "94efe68e2185646a17bb8a83268142a7b7a02786c1ee90ab8f7399ccf543de26",
+		expected: "da6389d151e3b2b332fbcc02b2cc5af4f69835e9fbbe19130f91cbf30a3c2458" +
+			"0ebfb4311b3487e9d6a61de9dc2842c107152158b0e75a932c38e5b8ddff10e6",
+	},
+}
+
+// predictionResistance returns whether test reseeds before each request.
+func (t *drbgTest) predictionResistance() bool {
+	return t.predictionEntropy1 != ""
+}
+
+// input returns every entropy input of test in the order read by DRBG.
+func (t *drbgTest) input() []byte {
+	return mustDecodeHex(t.entropy + t.nonce + t.reseedEntropy +
+		t.predictionEntropy1 + t.predictionEntropy2)
+}
+
+func mustDecodeHex(s string) []byte {
+	result, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return result
+}
+
+func TestDRBGKnownAnswers(t *testing.T) {
+	for _, v := range drbgTests {
+		d := v.mechanism()
+		d.instantiate(mustDecodeHex(v.entropy), mustDecodeHex(v.nonce),
+			mustDecodeHex(v.personalization))
+		if v.reseedEntropy != "" {
+			d.reseed(mustDecodeHex(v.reseedEntropy),
+				mustDecodeHex(v.additionalReseed))
+		}
+
+		expected := mustDecodeHex(v.expected)
+		buf := make([]byte, len(expected))
+		requests := []struct{ entropy, additional string }{
+			{v.predictionEntropy1, v.additional1},
+			{v.predictionEntropy2, v.additional2},
+		}
+		for _, r := range requests {
+			if v.predictionResistance() {
+				d.reseed(mustDecodeHex(r.entropy), mustDecodeHex(r.additional))
+				d.generate(buf, nil)
+			} else {
+				d.generate(buf, mustDecodeHex(r.additional))
+			}
+		}
+
+		if !bytes.Equal(buf, expected) {
+			t.Errorf("%s: unexpected output %x", v.name, buf)
+		}
+	}
+}
+
+func TestDRBGInstantiate(t *testing.T) {
+	for _, v := range drbgTests {
+		if v.predictionResistance() {
+			continue
+		}
+
+		rnd, err := v.constructor(bytes.NewReader(v.input()),
+			mustDecodeHex(v.personalization))
+		if err != nil {
+			t.Fatalf("%s: error instantiating DRBG: %v", v.name, err)
+		}
+		if v.reseedEntropy != "" {
+			if err := rnd.Reseed(mustDecodeHex(v.additionalReseed)); err != nil {
+				t.Fatalf("%s: error reseeding: %v", v.name, err)
+			}
+		}
+
+		expected := mustDecodeHex(v.expected)
+		buf := make([]byte, len(expected))
+		rnd.Generate(buf, mustDecodeHex(v.additional1))
+		rnd.Generate(buf, mustDecodeHex(v.additional2))
+		if !bytes.Equal(buf, expected) {
+			t.Errorf("%s: unexpected output %x", v.name, buf)
+		}
+		if n := rnd.ReseedCounter(); n != 3 {
+			t.Errorf("%s: unexpected reseed counter %d", v.name, n)
+		}
+	}
+}
+
+func TestDRBGPredictionResistance(t *testing.T) {
+	for _, v := range drbgTests {
+		if !v.predictionResistance() {
+			continue
+		}
+
+		rnd, err := v.constructor(bytes.NewReader(v.input()),
+			mustDecodeHex(v.personalization))
+		if err != nil {
+			t.Fatalf("%s: error instantiating DRBG: %v", v.name, err)
+		}
+		rnd.SetPredictionResistance(true)
+
+		expected := mustDecodeHex(v.expected)
+		buf := make([]byte, len(expected))
+		if err := rnd.Generate(buf, mustDecodeHex(v.additional1)); err != nil {
+			t.Fatalf("%s: error generating data: %v", v.name, err)
+		}
+		if err := rnd.Generate(buf, mustDecodeHex(v.additional2)); err != nil {
+			t.Fatalf("%s: error generating data: %v", v.name, err)
+		}
+		if !bytes.Equal(buf, expected) {
+			t.Errorf("%s: unexpected output %x", v.name, buf)
+		}
+
+		if err := rnd.Generate(buf, nil); err != io.EOF {
+			t.Errorf("%s: should fail without entropy input: got %v",
+				v.name, err)
+		}
+	}
+}
+
+func TestDRBGReseedInterval(t *testing.T) {
+	source := &LimitedSource{1, drbgEntropySize*4 + drbgNonceSize}
+	rnd, err := NewCTRDRBG(source, []byte("interval"))
+	if err != nil {
+		t.Fatalf("Error instantiating DRBG: %v", err)
+	}
+	rnd.SetReseedInterval(2)
+
+	buf := make([]byte, 16)
+	for i := 0; i < 6; i++ {
+		if err := rnd.Generate(buf, nil); err != nil {
+			t.Fatalf("Request %d: error generating data: %v", i, err)
+		}
+	}
+	if source.size != drbgEntropySize {
+		t.Errorf("Should reseed every 2 requests: %d bytes left",
+			source.size)
+	}
+
+	if err := rnd.Reseed(nil); err != nil {
+		t.Fatalf("Error reseeding: %v", err)
+	}
+	if n := rnd.ReseedCounter(); n != 1 {
+		t.Errorf("Reseed should reset counter: got %d", n)
+	}
+	if err := rnd.Reseed(nil); err != io.EOF {
+		t.Errorf("Should fail without entropy input: got %v", err)
+	}
+}
+
+func TestDRBGLargeRequest(t *testing.T) {
+	rnd, err := NewHMACDRBG(NewRandomAggr().FastSet(), []byte("large"))
+	if err != nil {
+		t.Fatalf("Error instantiating DRBG: %v", err)
+	}
+	defer rnd.Close()
+
+	buf := make([]byte, drbgMaxRequest*2+1)
+	n, err := rnd.Read(buf)
+	if err != nil || n != len(buf) {
+		t.Fatalf("Should fill entire buffer: read %d bytes: %v", n, err)
+	}
+	if c := rnd.ReseedCounter(); c != 4 {
+		t.Errorf("Should split request: got counter %d", c)
+	}
+}
+
+func TestDRBGErrors(t *testing.T) {
+	if _, err := NewHashDRBG(&LimitedSource{1, 10}, nil); err == nil {
+		t.Error("Should fail without enough entropy input")
+	}
+
+	rnd, _ := NewHashDRBG(NewRandomAggr().FastSet(), nil)
+	rnd.Close()
+	if _, err := rnd.Read(make([]byte, 1)); err != ErrClosed {
+		t.Errorf("Read should return ErrClosed: got %v", err)
+	}
+	if err := rnd.Reseed(nil); err != ErrClosed {
+		t.Errorf("Reseed should return ErrClosed: got %v", err)
+	}
+}
+
+func TestDRBGUnpredictability(t *testing.T) {
+	aggr := NewRandomAggr().SecureSet()
+	defer aggr.Close()
+
+	rnd, err := NewCTRDRBG(aggr, nil)
+	if err != nil {
+		t.Fatalf("Error instantiating DRBG: %v", err)
+	}
+	defer rnd.Close()
+
+	dups, stddev := testUnpred(rnd)
+
+	if dups > MaximumDups {
+		t.Errorf(
+			"DRBG random generator: %d dups of %d",
+			int(TestingRounds*dups), TestingRounds)
+	}
+	if stddev < MinimumStandardDeviation {
+		t.Errorf(
+			"DRBG random generator: %.2f STDDEV (%.2f minimum)",
+			stddev, MinimumStandardDeviation)
+	}
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"crypto/sha256"
+	"reflect"
+)
+
+// maxCreditedBits defines the maximum entropy credited by a single call to
+// AddEntropy, which is limited by the hash size.
+const maxCreditedBits = sha256.Size * 8
+
+// An EntropyAdder accepts entropy supplied by application, like timing of
+// unpredictable events.
+type EntropyAdder interface {
+	// AddEntropy mixes data into internal state and credits up to
+	// creditedBits of entropy.
+	AddEntropy(data []byte, creditedBits int) error
+}
+
+// An entropyForwarder is an EntropyAdder which forwards entropy to another
+// instance, like a reference to a shared instance.
+type entropyForwarder interface {
+	EntropyAdder
+
+	// entropyTarget returns the instance which receives entropy.
+	entropyTarget() EntropyAdder
+}
+
+// AddEntropy mixes application-supplied data into entropy pool by hashing it,
+// and credits the estimated entropy of data in bits. Credited entropy is
+// capped to the data size, to the hash size and to free space on entropy
+// pool, and it is tracked apart from timer samples.
+//
+// Returns ErrClosed when current instance is closed.
+func (s *SSTDEG) AddEntropy(data []byte, creditedBits int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	hash := sha256.New()
+	hash.Write(s.chain[:])
+	hash.Write(data)
+	hash.Sum(s.chain[:0])
+
+	// Pool only receives a hash of chain, so served bytes never reveal the
+	// state mixed into next calls.
+	hash.Reset()
+	hash.Write([]byte("pool"))
+	hash.Write(s.chain[:])
+	var output [sha256.Size]byte
+	hash.Sum(output[:0])
+
+	credit := capCredit(creditedBits, len(data))
+	s.credit += credit
+	for _, v := range output {
+		if s.credit >= 8 && s.pool.size < len(s.pool.data) {
+			s.pool.add(v)
+			s.credit -= 8
+		} else {
+			s.pool.stir(v)
+		}
+	}
+	if s.pool.size == len(s.pool.data) {
+		s.credit = 0
+	}
+
+	s.metrics.Add(MetricSSTDEGExternalBits, "", int64(credit))
+	s.metrics.Set(MetricSSTDEGPoolSize, "", int64(s.pool.size))
+	return nil
+}
+
+// AddEntropy mixes application-supplied data into shared instance.
+//
+// Returns ErrClosed when current reference is released.
+func (s *SharedSSTDEG) AddEntropy(data []byte, creditedBits int) error {
+	if s.released() {
+		return ErrClosed
+	}
+
+	return s.instance.AddEntropy(data, creditedBits)
+}
+
+func (s *SharedSSTDEG) entropyTarget() EntropyAdder {
+	return s.instance
+}
+
+// AddEntropy mixes application-supplied data into internal state by hashing
+// it. No entropy is credited since output is always generated from timing
+// jitter.
+//
+// Returns ErrClosed when current instance is closed.
+func (s *CPUJitter) AddEntropy(data []byte, creditedBits int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	hash := sha256.New()
+	hash.Write(s.seed[:])
+	hash.Write(data)
+	hash.Sum(s.seed[:0])
+	return nil
+}
+
+// AddEntropy mixes application-supplied data into every source which accepts
+// it. Each source credits entropy independently, and references to a shared
+// instance receive data once.
+//
+// Returns the first error returned by a source, or ErrClosed when current
+// instance is closed.
+func (s *RandomAggr) AddEntropy(data []byte, creditedBits int) error {
+	set, err := s.acquire()
+	if err != nil {
+		return err
+	}
+	defer set.readers.Done()
+
+	visited := make([]EntropyAdder, 0, len(set.sources))
+
+	for _, v := range set.sources {
+		adder, ok := v.Reader.(EntropyAdder)
+		if !ok {
+			continue
+		}
+		target := adder
+		if forwarder, ok := adder.(entropyForwarder); ok {
+			target = forwarder.entropyTarget()
+		}
+		if containsAdder(visited, target) {
+			continue
+		}
+		visited = append(visited, target)
+
+		itemErr := adder.AddEntropy(data, creditedBits)
+		if err == nil && itemErr != nil {
+			err = &SourceError{v.Name, itemErr}
+		}
+	}
+
+	return err
+}
+
+// capCredit limits credited bits to data size and to hash size.
+func capCredit(creditedBits, size int) int {
+	if creditedBits < 0 {
+		return 0
+	}
+	if creditedBits > size*8 {
+		creditedBits = size * 8
+	}
+	if creditedBits > maxCreditedBits {
+		creditedBits = maxCreditedBits
+	}
+
+	return creditedBits
+}
+
+// containsAdder determines whether list contains specified instance.
+func containsAdder(list []EntropyAdder, adder EntropyAdder) bool {
+	if !reflect.TypeOf(adder).Comparable() {
+		return false
+	}
+
+	for _, v := range list {
+		if v == adder {
+			return true
+		}
+	}
+
+	return false
+}
+
+var _ EntropyAdder = (*SSTDEG)(nil)
+var _ EntropyAdder = (*SharedSSTDEG)(nil)
+var _ EntropyAdder = (*CPUJitter)(nil)
+var _ EntropyAdder = (*RandomAggr)(nil)
+var _ entropyForwarder = (*SharedSSTDEG)(nil)
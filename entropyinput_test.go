@@ -0,0 +1,202 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+type countingAdder struct {
+	InfiniteSource
+	calls int
+	bits  int
+}
+
+func (a *countingAdder) AddEntropy(data []byte, creditedBits int) error {
+	a.calls++
+	a.bits += creditedBits
+	return nil
+}
+
+func TestSSTDEGAddEntropyCredit(t *testing.T) {
+	reg := NewMetricsRegistry()
+	rnd := NewSSTDEGWithOptions(SSTDEGOptions{
+		Clock:   newFakeClock(),
+		Metrics: reg,
+	})
+	defer rnd.Close()
+
+	tests := []struct {
+		data     []byte
+		bits     int
+		credited int
+		size     int
+	}{
+		{[]byte("request"), 4, 4, 0},
+		{[]byte("request"), 4, 4, 1},
+		{[]byte("x"), 100, 8, 2},
+		{make([]byte, 100), 1000, maxCreditedBits, 2 + sha256.Size},
+		{[]byte("negative"), -5, 0, 2 + sha256.Size},
+	}
+
+	total := 0
+	for i, v := range tests {
+		if err := rnd.AddEntropy(v.data, v.bits); err != nil {
+			t.Fatalf("Error adding entropy: %v", err)
+		}
+
+		total += v.credited
+		if got := reg.Value(MetricSSTDEGExternalBits, ""); got != int64(total) {
+			t.Errorf("Test %d: should credit %d bits: got %d",
+				i, total, got)
+		}
+		if n := rnd.EntropyAvailable(); n != v.size {
+			t.Errorf("Test %d: unexpected entropy available: got %d instead of %d",
+				i, n, v.size)
+		}
+	}
+}
+
+func TestSSTDEGAddEntropyFullPool(t *testing.T) {
+	clock := newFakeClock(sequence(defaultPoolSize)...)
+	rnd := NewSSTDEGWithClock(clock)
+	defer rnd.Close()
+	clock.wait()
+
+	before := rnd.pool.data
+	if err := rnd.AddEntropy([]byte("event"), 40); err != nil {
+		t.Fatalf("Error adding entropy: %v", err)
+	}
+
+	if n := rnd.EntropyAvailable(); n != defaultPoolSize {
+		t.Errorf("Entropy available should be capped: got %d", n)
+	}
+	if rnd.credit != 0 {
+		t.Errorf("Credit should be discarded on full pool: got %d",
+			rnd.credit)
+	}
+	if bytes.Equal(before[:], rnd.pool.data[:]) {
+		t.Error("Data should be mixed into entropy pool")
+	}
+}
+
+func TestSSTDEGAddEntropyChain(t *testing.T) {
+	rnd := newIdleSSTDEG()
+	defer rnd.Close()
+
+	if err := rnd.AddEntropy(make([]byte, 100), maxCreditedBits); err != nil {
+		t.Fatalf("Error adding entropy: %v", err)
+	}
+
+	buf := make([]byte, sha256.Size)
+	if _, err := rnd.Read(buf); err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	if bytes.Equal(buf, rnd.chain[:]) {
+		t.Error("Served data should not reveal internal chain")
+	}
+}
+
+func TestSSTDEGAddEntropyClosed(t *testing.T) {
+	rnd := newIdleSSTDEG()
+	rnd.Close()
+
+	if err := rnd.AddEntropy([]byte("event"), 8); err != ErrClosed {
+		t.Errorf("Should return ErrClosed: got %v", err)
+	}
+
+	shared := AcquireSSTDEG()
+	shared.Close()
+	if err := shared.AddEntropy([]byte("event"), 8); err != ErrClosed {
+		t.Errorf("Should return ErrClosed: got %v", err)
+	}
+}
+
+func TestCPUJitterAddEntropy(t *testing.T) {
+	read := func(data []byte) []byte {
+		rnd := NewCPUJitterWithClock(&tickClock{seed: 1})
+		defer rnd.Close()
+
+		if data != nil {
+			rnd.AddEntropy(data, 8)
+		}
+		buff := make([]byte, 32)
+		rnd.Read(buff)
+		return buff
+	}
+
+	if bytes.Equal(read(nil), read([]byte("event"))) {
+		t.Error("Data should be mixed into internal state")
+	}
+}
+
+func TestRandomAggrAddEntropy(t *testing.T) {
+	first := &countingAdder{}
+	second := &countingAdder{}
+	rnd := NewRandomAggr().
+		Add(first, 1).
+		Add(InfiniteSource(1), 1).
+		Add(second, 1).
+		Add(first, 1).
+		Build()
+
+	if err := rnd.AddEntropy([]byte("event"), 8); err != nil {
+		t.Fatalf("Error adding entropy: %v", err)
+	}
+
+	if first.calls != 1 || second.calls != 1 {
+		t.Errorf("Each source should receive data once: got %d and %d",
+			first.calls, second.calls)
+	}
+	if first.bits != 8 {
+		t.Errorf("Should forward credited bits: got %d", first.bits)
+	}
+}
+
+func TestRandomAggrAddEntropyShared(t *testing.T) {
+	reg := NewMetricsRegistry()
+	rnd := NewRandomAggr().AddSSTDEG(1).AddSSTDEG(1).Build()
+	defer rnd.Close()
+	SetSharedSSTDEGMetrics(reg)
+	defer SetSharedSSTDEGMetrics(nil)
+
+	if err := rnd.AddEntropy(make([]byte, 32), 256); err != nil {
+		t.Fatalf("Error adding entropy: %v", err)
+	}
+	if v := reg.Value(MetricSSTDEGExternalBits, ""); v != 256 {
+		t.Errorf("Shared instance should be credited once: got %d bits", v)
+	}
+}
+
+func TestRandomAggrAddEntropyError(t *testing.T) {
+	closed := NewSSTDEGWithClock(newFakeClock())
+	closed.Close()
+	rnd := NewRandomAggr().
+		Add(&countingAdder{}, 1).
+		AddNamed("pool", closed, 1).
+		Build()
+
+	err := rnd.AddEntropy([]byte("event"), 8)
+	if err, ok := err.(*SourceError); !ok || err.Source != "pool" ||
+		err.Err != ErrClosed {
+		t.Errorf("Error should identify source by name: got %v", err)
+	}
+}
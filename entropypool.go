@@ -0,0 +1,108 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+// poolRefillSamples defines how many samples must be mixed into a consumed
+// byte of entropy pool before it is available again.
+const poolRefillSamples = 2
+
+// An entropyPool stores random samples in a ring buffer. Samples are consumed
+// in the order they were added, and samples added to a full pool are mixed
+// uniformly across all of its positions. Consumed positions are only made
+// available again after poolRefillSamples samples were mixed into them.
+type entropyPool struct {
+	data     [defaultPoolSize]byte
+	mixes    [defaultPoolSize]int
+	consumed [defaultPoolSize]bool
+	head     int
+	size     int
+	cursor   int
+}
+
+// add stores a sample on the pool. When pool is full the sample is XORed into
+// the next position of a cursor which walks the whole pool. Returns whether the
+// sample was mixed into a full pool.
+func (p *entropyPool) add(n byte) bool {
+	if p.size < len(p.data) {
+		i := (p.head + p.size) % len(p.data)
+		p.data[i] ^= n
+		p.mixes[i]++
+		if p.consumed[i] && p.mixes[i] < poolRefillSamples {
+			return false
+		}
+
+		p.consumed[i] = false
+		p.size++
+		return false
+	}
+
+	p.stir(n)
+	return true
+}
+
+// stir XORs a sample into the next position of the mixing cursor without
+// making more bytes available.
+func (p *entropyPool) stir(n byte) {
+	p.data[p.cursor] ^= n
+	p.mixes[p.cursor]++
+	p.cursor = (p.cursor + 1) % len(p.data)
+}
+
+// take removes up to len(b) bytes from the pool, oldest first, and returns how
+// many were removed. Consumed positions are erased and marked as consumed.
+func (p *entropyPool) take(b []byte) int {
+	n := len(b)
+	if n > p.size {
+		n = p.size
+	}
+
+	for i := 0; i < n; i++ {
+		b[i] = p.data[p.head]
+		p.data[p.head] = 0
+		p.mixes[p.head] = 0
+		p.consumed[p.head] = true
+		p.head = (p.head + 1) % len(p.data)
+	}
+
+	p.size -= n
+	return n
+}
+
+// mix XORs specified data into every position of the pool, starting from its
+// oldest byte.
+func (p *entropyPool) mix(data []byte) {
+	for i, v := range data {
+		j := (p.head + i) % len(p.data)
+		p.data[j] ^= v
+		p.mixes[j]++
+	}
+}
+
+// credit marks up to n more positions of the pool as available.
+func (p *entropyPool) credit(n int) {
+	for ; n > 0 && p.size < len(p.data); n-- {
+		p.consumed[(p.head+p.size)%len(p.data)] = false
+		p.size++
+	}
+}
+
+// erase zeroes the pool.
+func (p *entropyPool) erase() {
+	*p = entropyPool{}
+}
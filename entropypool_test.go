@@ -0,0 +1,177 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import "testing"
+
+// mixesRange returns the minimum and maximum samples mixed into pool bytes.
+func mixesRange(p *entropyPool) (int, int) {
+	min, max := p.mixes[0], p.mixes[0]
+	for _, v := range p.mixes {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+
+	return min, max
+}
+
+func TestEntropyPoolOrder(t *testing.T) {
+	var pool entropyPool
+	for _, v := range sequence(10) {
+		pool.add(v)
+	}
+
+	buf := make([]byte, 4)
+	if n := pool.take(buf); n != len(buf) {
+		t.Fatalf("Should take %d bytes: took %d", len(buf), n)
+	}
+	testSequence(buf, 0, t)
+
+	buf = make([]byte, 10)
+	if n := pool.take(buf); n != 6 {
+		t.Fatalf("Should take available bytes only: took %d", n)
+	}
+	testSequence(buf[:6], 4, t)
+}
+
+func TestEntropyPoolWrap(t *testing.T) {
+	var pool entropyPool
+	for _, v := range sequence(defaultPoolSize) {
+		pool.add(v)
+	}
+
+	pool.take(make([]byte, 100))
+	for i := 0; i < 100; i++ {
+		if pool.data[i] != 0 || pool.mixes[i] != 0 {
+			t.Fatalf("Consumed byte should be erased at %d", i)
+		}
+	}
+
+	samples := sequence(100 * poolRefillSamples)
+	for _, v := range samples {
+		if pool.add(v) {
+			t.Fatal("Consumed positions should be refilled before mixing")
+		}
+	}
+	if pool.size != defaultPoolSize {
+		t.Fatalf("Pool should be full: got %d", pool.size)
+	}
+
+	buf := make([]byte, defaultPoolSize)
+	pool.take(buf)
+	for i, v := range buf[:defaultPoolSize-100] {
+		if v != byte(i+100) {
+			t.Fatalf("Unexpected value at %d: got %d", i, v)
+		}
+	}
+	for i, v := range buf[defaultPoolSize-100:] {
+		var expected byte
+		for _, s := range samples[i*poolRefillSamples : (i+1)*poolRefillSamples] {
+			expected ^= s
+		}
+		if v != expected {
+			t.Fatalf("Refilled byte %d should mix %d samples: got %d",
+				i, poolRefillSamples, v)
+		}
+	}
+}
+
+func TestEntropyPoolRefill(t *testing.T) {
+	var pool entropyPool
+	pool.add(1)
+	pool.take(make([]byte, 1))
+
+	for _, v := range sequence(defaultPoolSize) {
+		pool.add(v)
+	}
+	if pool.size != defaultPoolSize-1 {
+		t.Fatalf("Consumed byte should not be available: got %d bytes",
+			pool.size)
+	}
+
+	pool.add(7)
+	if pool.size != defaultPoolSize {
+		t.Fatalf("Consumed byte should be available after %d samples",
+			poolRefillSamples)
+	}
+	if pool.mixes[0] != poolRefillSamples {
+		t.Errorf("Consumed byte should mix %d samples: got %d",
+			poolRefillSamples, pool.mixes[0])
+	}
+}
+
+func TestEntropyPoolMixingDistribution(t *testing.T) {
+	var pool entropyPool
+	samples := defaultPoolSize*3 + 17
+
+	for i := 0; i < samples; i++ {
+		pool.add(byte(i))
+	}
+
+	min, max := mixesRange(&pool)
+	if max-min > 1 {
+		t.Errorf("Samples should be mixed uniformly: %d to %d per byte",
+			min, max)
+	}
+	if min != samples/defaultPoolSize {
+		t.Errorf("Every byte should be mixed: got %d samples minimum", min)
+	}
+
+	counts := make(map[int]int)
+	for _, v := range pool.mixes {
+		counts[v]++
+	}
+	if counts[max] != samples%defaultPoolSize {
+		t.Errorf("Unexpected count of bytes mixed %d times: got %d",
+			max, counts[max])
+	}
+}
+
+func TestEntropyPoolMix(t *testing.T) {
+	var pool entropyPool
+	pool.add(1)
+
+	data := make([]byte, defaultPoolSize)
+	for i := range data {
+		data[i] = 0xff
+	}
+	pool.mix(data)
+	pool.credit(defaultPoolSize)
+
+	min, max := mixesRange(&pool)
+	if min != 1 || max != 2 {
+		t.Errorf("Every byte should be mixed: %d to %d per byte", min, max)
+	}
+	if pool.data[0] != 0xfe {
+		t.Errorf("Data should be XORed into pool: got %d", pool.data[0])
+	}
+}
+
+func testSequence(b []byte, start byte, t *testing.T) {
+	for i, v := range b {
+		if v != start+byte(i) {
+			t.Errorf("Unexpected value at %d: got %d instead of %d",
+				i, v, start+byte(i))
+		}
+	}
+}
@@ -0,0 +1,294 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// fortunaPools defines the count of entropy pools of Fortuna accumulator.
+	fortunaPools = 32
+
+	// fortunaMinPoolSize defines how many bytes the first pool must hold
+	// before a reseed.
+	fortunaMinPoolSize = 64
+
+	// fortunaReseedInterval defines the minimum time between reseeds.
+	fortunaReseedInterval = 100 * time.Millisecond
+
+	// fortunaEventSize defines how many bytes are read from each source on
+	// every event.
+	fortunaEventSize = 32
+
+	// fortunaMaxRequest defines the maximum bytes generated before the
+	// generator key is replaced.
+	fortunaMaxRequest = 1 << 20
+
+	// fortunaMaxSeedRounds defines how many rounds of events are gathered
+	// waiting for first reseed.
+	fortunaMaxSeedRounds = fortunaPools * 64
+)
+
+// ErrNotSeeded is returned by Fortuna when its generator could not be seeded.
+var ErrNotSeeded = errors.New("Fortuna generator is not seeded")
+
+// A Fortuna provides a cryptographically secure pseudo-random generator as
+// designed by Ferguson and Schneier. It gathers events from its sources into
+// 32 entropy pools and reseeds an AES-256 generator in counter mode from them.
+type Fortuna struct {
+	sources    []io.Reader
+	next       []int
+	appNext    int
+	pools      [fortunaPools]hash.Hash
+	pool0Size  int
+	reseeds    uint64
+	lastReseed time.Time
+	failures   int
+	key        [sha256.Size]byte
+	counter    [aes.BlockSize]byte
+	clock      Clock
+	closed     bool
+	mutex      *sync.Mutex
+}
+
+// NewFortuna creates a new instance of Fortuna which gathers events from
+// specified sources.
+func NewFortuna(sources ...io.Reader) *Fortuna {
+	return NewFortunaWithClock(systemClock{}, sources...)
+}
+
+// NewFortunaWithClock creates a new instance of Fortuna which uses specified
+// clock to schedule reseeds.
+func NewFortunaWithClock(clock Clock, sources ...io.Reader) *Fortuna {
+	result := &Fortuna{
+		sources: sources,
+		next:    make([]int, len(sources)),
+		clock:   clock,
+		mutex:   &sync.Mutex{},
+	}
+	for i := range result.pools {
+		result.pools[i] = sha256.New()
+	}
+
+	return result
+}
+
+// AddEntropy adds application-supplied data as an event. Events are
+// distributed across pools, so entropy is not credited.
+//
+// Returns ErrClosed when current instance is closed.
+func (s *Fortuna) AddEntropy(data []byte, creditedBits int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	for len(data) > 0 {
+		event := data
+		if len(event) > fortunaEventSize {
+			event = event[:fortunaEventSize]
+		}
+		data = data[len(event):]
+
+		s.addEvent(byte(len(s.sources)), s.appNext, event)
+		s.appNext = (s.appNext + 1) % fortunaPools
+	}
+
+	return nil
+}
+
+// Close closes io.Closer sources and erases internal state.
+func (s *Fortuna) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	for i := range s.pools {
+		s.pools[i].Reset()
+	}
+	s.key = [sha256.Size]byte{}
+	s.counter = [aes.BlockSize]byte{}
+
+	var err error
+	for _, v := range s.sources {
+		if closer, ok := v.(io.Closer); ok {
+			itemErr := closer.Close()
+			if err == nil {
+				err = itemErr
+			}
+		}
+	}
+
+	return err
+}
+
+// Read fills specified byte array with random data. Before generating data it
+// gathers an event from each source and reseeds the generator when scheduled.
+// Once the generator is seeded, failing sources are skipped.
+//
+// Returns ErrNotSeeded when sources cannot provide enough events to seed the
+// generator.
+func (s *Fortuna) Read(b []byte) (n int, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	if err = s.gather(); err != nil {
+		return
+	}
+	s.reseed()
+	for i := 0; s.reseeds == 0; i++ {
+		if i == fortunaMaxSeedRounds || len(s.sources) == 0 {
+			return 0, ErrNotSeeded
+		}
+		if err = s.gather(); err != nil {
+			return
+		}
+		s.reseed()
+	}
+
+	for n < len(b) {
+		end := n + fortunaMaxRequest
+		if end > len(b) {
+			end = len(b)
+		}
+
+		s.generate(b[n:end])
+		n = end
+	}
+
+	return n, nil
+}
+
+// addEvent adds data of specified source into a pool.
+func (s *Fortuna) addEvent(source byte, pool int, data []byte) {
+	s.pools[pool].Write([]byte{source, byte(len(data))})
+	s.pools[pool].Write(data)
+	if pool == 0 {
+		s.pool0Size += len(data) + 2
+	}
+}
+
+// gather reads an event from each source, which are distributed across pools
+// in round-robin. Once the generator is seeded, failing sources are counted
+// and skipped instead of failing.
+func (s *Fortuna) gather() error {
+	buf := make([]byte, fortunaEventSize)
+
+	for i, v := range s.sources {
+		if _, err := io.ReadFull(v, buf); err != nil {
+			if s.reseeds == 0 {
+				return err
+			}
+
+			s.failures++
+			continue
+		}
+
+		s.addEvent(byte(i), s.next[i], buf)
+		s.next[i] = (s.next[i] + 1) % fortunaPools
+	}
+
+	return nil
+}
+
+// reseed replaces generator key using pools scheduled for next reseed, when
+// first pool holds enough data and minimum interval was elapsed.
+func (s *Fortuna) reseed() {
+	now := s.clock.Now()
+	if s.pool0Size < fortunaMinPoolSize ||
+		(s.reseeds > 0 && now.Sub(s.lastReseed) < fortunaReseedInterval) {
+		return
+	}
+
+	s.reseeds++
+	s.lastReseed = now
+
+	seed := sha256.New()
+	seed.Write(s.key[:])
+	for _, i := range reseedPools(s.reseeds) {
+		seed.Write(s.pools[i].Sum(nil))
+		s.pools[i].Reset()
+	}
+	s.pool0Size = 0
+
+	s.key = sha256.Sum256(seed.Sum(nil))
+	s.increment()
+}
+
+// generate fills specified byte array, up to fortunaMaxRequest, by encrypting
+// counter blocks and then replaces generator key.
+func (s *Fortuna) generate(b []byte) {
+	block, _ := aes.NewCipher(s.key[:])
+	buf := make([]byte, aes.BlockSize)
+
+	for i := 0; i < len(b); i += aes.BlockSize {
+		block.Encrypt(buf, s.counter[:])
+		s.increment()
+		copy(b[i:], buf)
+	}
+
+	for i := 0; i < len(s.key); i += aes.BlockSize {
+		block.Encrypt(s.key[i:], s.counter[:])
+		s.increment()
+	}
+}
+
+// increment adds one to generator counter, which is little-endian.
+func (s *Fortuna) increment() {
+	for i := range s.counter {
+		s.counter[i]++
+		if s.counter[i] != 0 {
+			return
+		}
+	}
+}
+
+// reseedPools returns which pools are used by specified reseed, as pool i is
+// used when reseed count is a multiple of 2^i.
+func reseedPools(reseed uint64) []int {
+	result := make([]int, 0, 1)
+	for i := 0; i < fortunaPools; i++ {
+		if reseed%(uint64(1)<<uint(i)) != 0 {
+			break
+		}
+		result = append(result, i)
+	}
+
+	return result
+}
+
+var _ io.ReadCloser = (*Fortuna)(nil)
+var _ EntropyAdder = (*Fortuna)(nil)
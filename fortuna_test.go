@@ -0,0 +1,242 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// emptyPool returns whether specified pool of Fortuna has no data.
+func emptyPool(f *Fortuna, i int) bool {
+	empty := sha256.Sum256(nil)
+	return bytes.Equal(f.pools[i].Sum(nil), empty[:])
+}
+
+func TestReseedPools(t *testing.T) {
+	tests := []struct {
+		reseed uint64
+		pools  []int
+	}{
+		{1, []int{0}},
+		{2, []int{0, 1}},
+		{3, []int{0}},
+		{4, []int{0, 1, 2}},
+		{6, []int{0, 1}},
+		{8, []int{0, 1, 2, 3}},
+		{12, []int{0, 1, 2}},
+		{1 << 31, sequenceInts(32)},
+		{1 << 40, sequenceInts(32)},
+	}
+
+	for _, v := range tests {
+		if got := reseedPools(v.reseed); !reflect.DeepEqual(got, v.pools) {
+			t.Errorf("Reseed %d should use pools %v: got %v",
+				v.reseed, v.pools, got)
+		}
+	}
+}
+
+func TestFortunaReseedSchedule(t *testing.T) {
+	clock := newFakeClock()
+	rnd := NewFortunaWithClock(clock)
+	defer rnd.Close()
+
+	fill := func() {
+		for i := range rnd.pools {
+			rnd.addEvent(0, i, make([]byte, fortunaEventSize))
+			rnd.addEvent(1, i, make([]byte, fortunaEventSize))
+		}
+	}
+
+	fill()
+	rnd.reseed()
+	if rnd.reseeds != 1 {
+		t.Fatalf("Should reseed when first pool is full: %d", rnd.reseeds)
+	}
+	if !emptyPool(rnd, 0) || emptyPool(rnd, 1) {
+		t.Error("First reseed should use only first pool")
+	}
+
+	fill()
+	rnd.reseed()
+	if rnd.reseeds != 1 {
+		t.Error("Should not reseed before minimum interval")
+	}
+
+	clock.now = clock.now.Add(fortunaReseedInterval)
+	key := rnd.key
+	rnd.reseed()
+	if rnd.reseeds != 2 {
+		t.Fatal("Should reseed after minimum interval")
+	}
+	if rnd.key == key {
+		t.Error("Reseed should replace generator key")
+	}
+	for i := range rnd.pools {
+		if emptyPool(rnd, i) != (i < 2) {
+			t.Errorf("Second reseed should use two pools: pool %d", i)
+		}
+	}
+
+	clock.now = clock.now.Add(fortunaReseedInterval)
+	rnd.addEvent(0, 0, make([]byte, fortunaMinPoolSize-3))
+	rnd.reseed()
+	if rnd.reseeds != 2 {
+		t.Error("Should not reseed before first pool is full")
+	}
+}
+
+func TestFortunaSeeding(t *testing.T) {
+	rnd := NewFortunaWithClock(newFakeClock(), InfiniteSource(1))
+	defer rnd.Close()
+
+	buf := make([]byte, 100)
+	n, err := rnd.Read(buf)
+	if err != nil {
+		t.Fatalf("Error reading Fortuna: %v", err)
+	}
+	if n != len(buf) {
+		t.Errorf("Should fill entire buffer: read %d bytes", n)
+	}
+	if rnd.reseeds != 1 {
+		t.Errorf("Should seed generator once: got %d reseeds", rnd.reseeds)
+	}
+}
+
+func TestFortunaRekey(t *testing.T) {
+	read := func() ([]byte, []byte) {
+		rnd := NewFortunaWithClock(newFakeClock(),
+			InfiniteSource(1), InfiniteSource(2))
+		defer rnd.Close()
+
+		first := make([]byte, 64)
+		second := make([]byte, 64)
+		rnd.Read(first)
+		key := rnd.key
+		rnd.Read(second)
+		if key == rnd.key {
+			t.Error("Generator key should be replaced after each request")
+		}
+
+		return first, second
+	}
+
+	first, second := read()
+	if bytes.Equal(first, second) {
+		t.Error("Consecutive requests should differ")
+	}
+	if bytes.Equal(first[:16], first[16:32]) {
+		t.Error("Consecutive blocks should differ")
+	}
+
+	again, _ := read()
+	if !bytes.Equal(first, again) {
+		t.Error("Same events should generate same data")
+	}
+}
+
+func TestFortunaAddEntropy(t *testing.T) {
+	rnd := NewFortunaWithClock(newFakeClock())
+	defer rnd.Close()
+
+	rnd.AddEntropy(make([]byte, fortunaEventSize*3), 8)
+	for i := range rnd.pools {
+		if emptyPool(rnd, i) != (i >= 3) {
+			t.Errorf("Events should be distributed across pools: pool %d", i)
+		}
+	}
+}
+
+func TestFortunaNotSeeded(t *testing.T) {
+	rnd := NewFortunaWithClock(newFakeClock())
+	defer rnd.Close()
+
+	if _, err := rnd.Read(make([]byte, 1)); err != ErrNotSeeded {
+		t.Errorf("Should fail without sources: got %v", err)
+	}
+
+	failing := NewFortuna(&LimitedSource{1, 10})
+	if _, err := failing.Read(make([]byte, 1)); err != io.ErrUnexpectedEOF {
+		t.Errorf("Should fail when source fails: got %v", err)
+	}
+}
+
+func TestFortunaFailingSource(t *testing.T) {
+	rnd := NewFortunaWithClock(newFakeClock(),
+		&LimitedSource{1, fortunaEventSize * 3}, InfiniteSource(2))
+	defer rnd.Close()
+
+	for i := 0; i < 10; i++ {
+		if _, err := rnd.Read(make([]byte, 16)); err != nil {
+			t.Fatalf("Read %d should skip failing source: %v", i, err)
+		}
+	}
+	if rnd.failures != 7 {
+		t.Errorf("Should count source failures: got %d", rnd.failures)
+	}
+}
+
+func TestFortunaClosed(t *testing.T) {
+	rnd := NewFortuna(rand.Reader)
+	rnd.Close()
+
+	if _, err := rnd.Read(make([]byte, 1)); err != ErrClosed {
+		t.Errorf("Read should return ErrClosed: got %v", err)
+	}
+	if err := rnd.AddEntropy([]byte("event"), 8); err != ErrClosed {
+		t.Errorf("AddEntropy should return ErrClosed: got %v", err)
+	}
+}
+
+func TestFortunaUnpredictability(t *testing.T) {
+	rnd := NewRandomAggr().
+		Add(NewFortuna(rand.Reader, NewCPUJitter()), 1).
+		Build()
+	defer rnd.Close()
+
+	dups, stddev := testUnpred(rnd)
+
+	if dups > MaximumDups {
+		t.Errorf(
+			"Fortuna random generator: %d dups of %d",
+			int(TestingRounds*dups), TestingRounds)
+	}
+	if stddev < MinimumStandardDeviation {
+		t.Errorf(
+			"Fortuna random generator: %.2f STDDEV (%.2f minimum)",
+			stddev, MinimumStandardDeviation)
+	}
+	t.Logf(
+		"Fortuna random generator: %.2f%% dups/%.2f STDDEV",
+		dups*100, stddev)
+}
+
+func sequenceInts(count int) []int {
+	result := make([]int, count)
+	for i := range result {
+		result[i] = i
+	}
+
+	return result
+}
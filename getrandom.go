@@ -0,0 +1,98 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+package crypt
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Flags of getrandom(2) system call.
+const (
+	// GrndNonblock makes Getrandom fail with ErrWouldBlock instead of
+	// blocking when no random data is available.
+	GrndNonblock = 0x1
+
+	// GrndRandom makes Getrandom read from the blocking random pool, as
+	// /dev/random, instead of the urandom pool.
+	GrndRandom = 0x2
+)
+
+var (
+	// ErrWouldBlock is returned by Getrandom with GrndNonblock flag when
+	// reading would block.
+	ErrWouldBlock = errors.New("getrandom would block")
+
+	// ErrGetrandomUnsupported is returned by Getrandom when getrandom(2)
+	// system call is not available.
+	ErrGetrandomUnsupported = errors.New("getrandom is not supported")
+)
+
+// A Getrandom provides random data read by Linux getrandom(2) system call.
+type Getrandom struct {
+	flags  int
+	closed bool
+	mutex  *sync.Mutex
+}
+
+// NewGetrandom creates a new instance of Getrandom which calls getrandom(2)
+// with specified flags, a combination of GrndNonblock and GrndRandom.
+func NewGetrandom(flags int) *Getrandom {
+	return &Getrandom{
+		flags: flags,
+		mutex: &sync.Mutex{},
+	}
+}
+
+// Close marks current instance as closed.
+func (s *Getrandom) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// Read fills specified byte array with random data, calling getrandom(2) until
+// the buffer is filled, since GrndRandom may deliver fewer bytes per call.
+//
+// Returns ErrWouldBlock when GrndNonblock flag is set and no random data is
+// available, or ErrClosed when current instance is closed.
+func (s *Getrandom) Read(b []byte) (n int, err error) {
+	s.mutex.Lock()
+	closed := s.closed
+	s.mutex.Unlock()
+
+	if closed {
+		return 0, ErrClosed
+	}
+
+	for n < len(b) {
+		var c int
+		c, err = getrandom(b[n:], s.flags)
+		n += c
+		if err != nil {
+			return
+		}
+	}
+
+	return n, nil
+}
+
+var _ io.ReadCloser = (*Getrandom)(nil)
@@ -0,0 +1,71 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+package crypt
+
+import (
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// getrandomTrap defines the number of getrandom(2) system call for each
+// architecture, since it is not defined by syscall package for all of them.
+var getrandomTrap = map[string]uintptr{
+	"386":      355,
+	"amd64":    318,
+	"arm":      384,
+	"arm64":    278,
+	"loong64":  278,
+	"mips":     4353,
+	"mipsle":   4353,
+	"mips64":   5313,
+	"mips64le": 5313,
+	"ppc64":    359,
+	"ppc64le":  359,
+	"riscv64":  278,
+	"s390x":    349,
+}
+
+// getrandom calls getrandom(2) once to fill specified byte array, retrying
+// when interrupted by a signal.
+func getrandom(b []byte, flags int) (int, error) {
+	trap, ok := getrandomTrap[runtime.GOARCH]
+	if !ok {
+		return 0, ErrGetrandomUnsupported
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	for {
+		n, _, errno := syscall.Syscall(trap,
+			uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), uintptr(flags))
+		switch errno {
+		case 0:
+			return int(n), nil
+		case syscall.EINTR:
+			continue
+		case syscall.EAGAIN:
+			return 0, ErrWouldBlock
+		case syscall.ENOSYS:
+			return 0, ErrGetrandomUnsupported
+		default:
+			return 0, errno
+		}
+	}
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+package crypt
+
+import (
+	"testing"
+)
+
+func TestGetrandom(t *testing.T) {
+	flags := []int{0, GrndNonblock, GrndRandom | GrndNonblock}
+	for _, v := range flags {
+		rnd := NewGetrandom(v)
+
+		buf := make([]byte, 4096)
+		n, err := rnd.Read(buf)
+		if err == ErrWouldBlock && v&GrndNonblock != 0 {
+			continue
+		}
+		if err != nil || n != len(buf) {
+			t.Errorf("Flags %d: should fill entire buffer: read %d bytes: %v",
+				v, n, err)
+		}
+		if count := countByValue(buf)[0]; count > 64 {
+			t.Errorf("Flags %d: buffer was not filled: %d zeros", v, count)
+		}
+	}
+}
+
+func TestGetrandomUnpredictability(t *testing.T) {
+	rnd := NewGetrandom(0)
+	dups, stddev := testUnpred(rnd)
+
+	if dups > MaximumDups {
+		t.Errorf(
+			"Getrandom random generator: %d dups of %d",
+			int(TestingRounds*dups), TestingRounds)
+	}
+	if stddev < MinimumStandardDeviation {
+		t.Errorf(
+			"Getrandom random generator: %.2f STDDEV (%.2f minimum)",
+			stddev, MinimumStandardDeviation)
+	}
+}
+
+func TestGetrandomClosed(t *testing.T) {
+	rnd := NewGetrandom(0)
+	rnd.Close()
+
+	if _, err := rnd.Read(make([]byte, 1)); err != ErrClosed {
+		t.Errorf("Read should return ErrClosed: got %v", err)
+	}
+}
+
+func TestRandomAggrAddGetrandom(t *testing.T) {
+	rnd := NewRandomAggr().AddGetrandom(1, 0).AddSys(1).Build()
+	defer rnd.Close()
+
+	if n, err := rnd.Read(make([]byte, 100)); err != nil || n != 100 {
+		t.Fatalf("Should fill entire buffer: read %d bytes: %v", n, err)
+	}
+	if v := rnd.Sources()[0]; v.Name != "getrandom" || v.Bytes != 50 {
+		t.Errorf("Unexpected getrandom source: %v", v)
+	}
+}
@@ -0,0 +1,8 @@
+module github.com/raiqub/crypt
+
+go 1.22
+
+require (
+	github.com/GaryBoone/GoStats v0.0.0-20130122001700-1993eafbef57
+	gopkg.in/yaml.v2 v2.4.0
+)
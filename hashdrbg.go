@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// hashSeedSize defines the seed length of Hash_DRBG using SHA-256, which is
+// 440 bits.
+const hashSeedSize = 55
+
+// A hashDRBG implements Hash_DRBG mechanism using SHA-256.
+type hashDRBG struct {
+	value   []byte
+	c       []byte
+	counter uint64
+}
+
+func (d *hashDRBG) instantiate(entropy, nonce, personalization []byte) {
+	d.value = hashDF(hashSeedSize, entropy, nonce, personalization)
+	d.c = hashDF(hashSeedSize, []byte{0}, d.value)
+	d.counter = 1
+}
+
+func (d *hashDRBG) reseed(entropy, additional []byte) {
+	d.value = hashDF(hashSeedSize, []byte{1}, d.value, entropy, additional)
+	d.c = hashDF(hashSeedSize, []byte{0}, d.value)
+	d.counter = 1
+}
+
+func (d *hashDRBG) generate(b, additional []byte) {
+	if len(additional) > 0 {
+		hash := sha256.New()
+		hash.Write([]byte{2})
+		hash.Write(d.value)
+		hash.Write(additional)
+		addBigEndian(d.value, hash.Sum(nil))
+	}
+
+	data := append([]byte{}, d.value...)
+	for n := 0; n < len(b); {
+		sum := sha256.Sum256(data)
+		n += copy(b[n:], sum[:])
+		addBigEndian(data, []byte{1})
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte{3})
+	hash.Write(d.value)
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], d.counter)
+	addBigEndian(d.value, hash.Sum(nil))
+	addBigEndian(d.value, d.c)
+	addBigEndian(d.value, counter[:])
+	d.counter++
+}
+
+func (d *hashDRBG) erase() {
+	zero(d.value, d.c)
+	d.counter = 0
+}
+
+// hashDF implements Hash_df derivation function using SHA-256, which derives
+// size bytes from specified input.
+func hashDF(size int, input ...[]byte) []byte {
+	result := make([]byte, 0, size+sha256.Size)
+	var bits [4]byte
+	binary.BigEndian.PutUint32(bits[:], uint32(size*8))
+
+	for counter := byte(1); len(result) < size; counter++ {
+		hash := sha256.New()
+		hash.Write([]byte{counter})
+		hash.Write(bits[:])
+		for _, v := range input {
+			hash.Write(v)
+		}
+		result = hash.Sum(result)
+	}
+
+	return result[:size]
+}
+
+var _ drbgMechanism = (*hashDRBG)(nil)
@@ -0,0 +1,93 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// A hmacDRBG implements HMAC_DRBG mechanism using SHA-256.
+type hmacDRBG struct {
+	key   []byte
+	value []byte
+}
+
+func (d *hmacDRBG) instantiate(entropy, nonce, personalization []byte) {
+	d.key = make([]byte, sha256.Size)
+	d.value = make([]byte, sha256.Size)
+	for i := range d.value {
+		d.value[i] = 1
+	}
+
+	d.update(entropy, nonce, personalization)
+}
+
+func (d *hmacDRBG) reseed(entropy, additional []byte) {
+	d.update(entropy, additional)
+}
+
+func (d *hmacDRBG) generate(b, additional []byte) {
+	if len(additional) > 0 {
+		d.update(additional)
+	}
+
+	for n := 0; n < len(b); {
+		d.value = d.mac(d.value)
+		n += copy(b[n:], d.value)
+	}
+
+	d.update(additional)
+}
+
+func (d *hmacDRBG) erase() {
+	zero(d.key, d.value)
+}
+
+// update mixes provided data into key and value.
+func (d *hmacDRBG) update(provided ...[]byte) {
+	empty := true
+	for _, v := range provided {
+		if len(v) > 0 {
+			empty = false
+		}
+	}
+
+	for _, sep := range []byte{0, 1} {
+		if sep == 1 && empty {
+			return
+		}
+
+		d.key = d.mac(append(append([]byte{}, d.value...), sep), provided...)
+		d.value = d.mac(d.value)
+	}
+}
+
+// mac returns HMAC of specified data using current key.
+func (d *hmacDRBG) mac(data []byte, more ...[]byte) []byte {
+	m := hmac.New(sha256.New, d.key)
+	m.Write(data)
+	for _, v := range more {
+		m.Write(v)
+	}
+
+	return m.Sum(nil)
+}
+
+var _ drbgMechanism = (*hmacDRBG)(nil)
@@ -0,0 +1,226 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// MetricSSTDEGSamples counts raw samples collected by SSTDEG.
+	MetricSSTDEGSamples = "crypt_sstdeg_samples_total"
+
+	// MetricSSTDEGPoolSize measures how many bytes are available on SSTDEG
+	// entropy pool.
+	MetricSSTDEGPoolSize = "crypt_sstdeg_pool_bytes"
+
+	// MetricSSTDEGOverflows counts samples XORed into a full SSTDEG entropy
+	// pool.
+	MetricSSTDEGOverflows = "crypt_sstdeg_overflows_total"
+
+	// MetricSSTDEGHealthFailures counts samples discarded by SSTDEG health
+	// tests.
+	MetricSSTDEGHealthFailures = "crypt_sstdeg_health_failures_total"
+
+	// MetricSSTDEGExternalBits counts entropy bits credited to SSTDEG by
+	// application-supplied data.
+	MetricSSTDEGExternalBits = "crypt_sstdeg_external_entropy_bits_total"
+
+	// MetricSSTDEGBlockedRead measures the time in nanoseconds which reads
+	// spent waiting for SSTDEG entropy pool to be refilled.
+	MetricSSTDEGBlockedRead = "crypt_sstdeg_blocked_read_nanoseconds_total"
+
+	// MetricRandomAggrBytes counts bytes served by each RandomAggr source.
+	MetricRandomAggrBytes = "crypt_randomaggr_bytes_total"
+
+	// MetricRandomAggrErrors counts errors returned by each RandomAggr
+	// source.
+	MetricRandomAggrErrors = "crypt_randomaggr_errors_total"
+
+	// MetricBufferedAggrFill measures how many bytes of pre-generated random
+	// data are available on BufferedAggr.
+	MetricBufferedAggrFill = "crypt_bufferedaggr_fill_bytes"
+
+	// MetricBufferedAggrUnderruns counts reads which had to wait for
+	// BufferedAggr to be refilled.
+	MetricBufferedAggrUnderruns = "crypt_bufferedaggr_underruns_total"
+)
+
+// A Metrics receives operational metrics from random generators. The label
+// identifies the source of the metric and is empty when not applicable.
+type Metrics interface {
+	// Add adds delta to the counter identified by name and label.
+	Add(name, label string, delta int64)
+
+	// Set sets the gauge identified by name and label to value.
+	Set(name, label string, value int64)
+}
+
+// nopMetrics implements Metrics discarding every metric.
+type nopMetrics struct{}
+
+func (nopMetrics) Add(name, label string, delta int64) {}
+
+func (nopMetrics) Set(name, label string, value int64) {}
+
+// metricKey identifies a metric value.
+type metricKey struct {
+	name  string
+	label string
+}
+
+// A MetricsRegistry stores metrics in memory and exposes them to be scraped by
+// Prometheus using text exposition format.
+type MetricsRegistry struct {
+	mutex  sync.Mutex
+	values map[metricKey]int64
+	gauges map[string]bool
+}
+
+// NewMetricsRegistry creates a new instance of MetricsRegistry.
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		values: make(map[metricKey]int64),
+		gauges: make(map[string]bool),
+	}
+}
+
+// Add adds delta to the counter identified by name and label.
+func (r *MetricsRegistry) Add(name, label string, delta int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.values[metricKey{name, label}] += delta
+}
+
+// Set sets the gauge identified by name and label to value.
+func (r *MetricsRegistry) Set(name, label string, value int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.values[metricKey{name, label}] = value
+	r.gauges[name] = true
+}
+
+// Value returns current value of the metric identified by name and label.
+func (r *MetricsRegistry) Value(name, label string) int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.values[metricKey{name, label}]
+}
+
+// ServeHTTP writes current metrics using Prometheus text exposition format.
+func (r *MetricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(r.exposition())
+}
+
+// exposition formats current metrics using Prometheus text exposition format.
+func (r *MetricsRegistry) exposition() []byte {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	keys := make([]metricKey, 0, len(r.values))
+	for k := range r.values {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].label < keys[j].label
+	})
+
+	var buf bytes.Buffer
+	last := ""
+	for _, k := range keys {
+		if k.name != last {
+			kind := "counter"
+			if r.gauges[k.name] {
+				kind = "gauge"
+			}
+			fmt.Fprintf(&buf, "# TYPE %s %s\n", k.name, kind)
+			last = k.name
+		}
+
+		if k.label == "" {
+			fmt.Fprintf(&buf, "%s %d\n", k.name, r.values[k])
+		} else {
+			fmt.Fprintf(&buf, "%s{source=\"%s\"} %d\n",
+				k.name, escapeLabel(k.label), r.values[k])
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// escapeLabel escapes a label value for Prometheus text exposition format.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// An ExpvarMetrics implements Metrics publishing metrics to an expvar.Map.
+type ExpvarMetrics struct {
+	vars *expvar.Map
+}
+
+// NewExpvarMetrics creates a new instance of ExpvarMetrics which stores
+// metrics into specified map.
+func NewExpvarMetrics(vars *expvar.Map) *ExpvarMetrics {
+	return &ExpvarMetrics{vars}
+}
+
+// Add adds delta to the counter identified by name and label.
+func (m *ExpvarMetrics) Add(name, label string, delta int64) {
+	m.vars.Add(expvarKey(name, label), delta)
+}
+
+// Set sets the gauge identified by name and label to value.
+func (m *ExpvarMetrics) Set(name, label string, value int64) {
+	key := expvarKey(name, label)
+	if v, ok := m.vars.Get(key).(*expvar.Int); ok {
+		v.Set(value)
+		return
+	}
+
+	v := new(expvar.Int)
+	v.Set(value)
+	m.vars.Set(key, v)
+}
+
+// expvarKey returns the key of a metric on expvar map.
+func expvarKey(name, label string) string {
+	if label == "" {
+		return name
+	}
+
+	return name + ":" + label
+}
+
+var _ Metrics = nopMetrics{}
+var _ Metrics = (*MetricsRegistry)(nil)
+var _ Metrics = (*ExpvarMetrics)(nil)
+var _ http.Handler = (*MetricsRegistry)(nil)
@@ -0,0 +1,143 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"expvar"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsRegistryHandler(t *testing.T) {
+	reg := NewMetricsRegistry()
+	reg.Add(MetricRandomAggrBytes, "1", 10)
+	reg.Add(MetricRandomAggrBytes, "0", 5)
+	reg.Add(MetricRandomAggrBytes, "0", 5)
+	reg.Set(MetricSSTDEGPoolSize, "", 7)
+	reg.Add(MetricRandomAggrErrors, `a"b\c`, 1)
+
+	server := httptest.NewServer(reg)
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatalf("Error requesting metrics: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(
+		ct, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type: %s", ct)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Error reading metrics: %v", err)
+	}
+
+	expected := `# TYPE crypt_randomaggr_bytes_total counter
+crypt_randomaggr_bytes_total{source="0"} 10
+crypt_randomaggr_bytes_total{source="1"} 10
+# TYPE crypt_randomaggr_errors_total counter
+crypt_randomaggr_errors_total{source="a\"b\\c"} 1
+# TYPE crypt_sstdeg_pool_bytes gauge
+crypt_sstdeg_pool_bytes 7
+`
+	if string(body) != expected {
+		t.Errorf("Unexpected exposition:\n%s", body)
+	}
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	vars := new(expvar.Map).Init()
+	m := NewExpvarMetrics(vars)
+
+	m.Add(MetricRandomAggrBytes, "0", 3)
+	m.Add(MetricRandomAggrBytes, "0", 4)
+	m.Set(MetricSSTDEGPoolSize, "", 9)
+	m.Set(MetricSSTDEGPoolSize, "", 8)
+
+	if v := vars.Get(MetricRandomAggrBytes + ":0").String(); v != "7" {
+		t.Errorf("Unexpected counter value: %s", v)
+	}
+	if v := vars.Get(MetricSSTDEGPoolSize).String(); v != "8" {
+		t.Errorf("Unexpected gauge value: %s", v)
+	}
+}
+
+func TestSSTDEGMetrics(t *testing.T) {
+	reg := NewMetricsRegistry()
+	clock := newFakeClock(append(sequence(defaultPoolSize), 7, 7)...)
+	rnd := NewSSTDEGWithOptions(SSTDEGOptions{
+		Clock:   clock,
+		Metrics: reg,
+	})
+	defer rnd.Close()
+
+	clock.wait()
+	rnd.Read(make([]byte, 10))
+
+	if v := reg.Value(MetricSSTDEGSamples, ""); v != defaultPoolSize+2 {
+		t.Errorf("Unexpected samples count: %d", v)
+	}
+	if v := reg.Value(MetricSSTDEGOverflows, ""); v != 2 {
+		t.Errorf("Should count 2 overflows: got %d", v)
+	}
+	if v := reg.Value(MetricSSTDEGPoolSize, ""); v != defaultPoolSize-10 {
+		t.Errorf("Unexpected pool size: %d", v)
+	}
+}
+
+func TestSSTDEGBlockedReadMetrics(t *testing.T) {
+	reg := NewMetricsRegistry()
+	rnd := newFedSSTDEG()
+	rnd.SetMetrics(reg)
+	defer rnd.Close()
+
+	rnd.Read(make([]byte, defaultPoolSize*2))
+	if v := reg.Value(MetricSSTDEGBlockedRead, ""); v <= 0 {
+		t.Errorf("Should measure blocked read time: got %d", v)
+	}
+}
+
+func TestRandomAggrMetrics(t *testing.T) {
+	reg := NewMetricsRegistry()
+	rnd := NewRandomAggr().
+		Add(&LimitedSource{1, 10}, 5).
+		Add(InfiniteSource(2), 5).
+		Build()
+	rnd.SetMetrics(reg)
+
+	rnd.Read(make([]byte, 40))
+
+	if v := reg.Value(MetricRandomAggrBytes, "0"); v != 10 {
+		t.Errorf("Should count 10 bytes from first source: got %d", v)
+	}
+	if v := reg.Value(MetricRandomAggrBytes, "1"); v != 30 {
+		t.Errorf("Should count 30 bytes from second source: got %d", v)
+	}
+	if v := reg.Value(MetricRandomAggrErrors, "0"); v != 1 {
+		t.Errorf("Should count short read as error: got %d", v)
+	}
+	if v := reg.Value(MetricRandomAggrErrors, "1"); v != 0 {
+		t.Errorf("Should not count errors from second source: got %d", v)
+	}
+}
@@ -18,26 +18,207 @@
 
 package crypt
 
-import "io"
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math/bits"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// A MixMode defines how RandomAggr combines data from its sources.
+type MixMode int
+
+const (
+	// MixPartition fills disjoint portions of the buffer from each source, as
+	// specified by its weight.
+	MixPartition MixMode = iota
+
+	// MixXOR fills the entire buffer from each source and XOR them together,
+	// so every output byte depends on all sources. Weights are ignored.
+	MixXOR
+
+	// MixHash fills the entire buffer from each source and hash them
+	// together using SHA-256, so every output byte depends on all sources.
+	// Weights are ignored.
+	MixHash
+)
+
+// A FailurePolicy defines how RandomAggr handles sources which fail or cannot
+// deliver enough data.
+type FailurePolicy int
+
+const (
+	// FailRedistribute reads the shortfall of failing sources from remaining
+	// ones. Fails only when all sources fail.
+	FailRedistribute FailurePolicy = iota
+
+	// FailClosed stops reading and returns an error identifying the failing
+	// source.
+	FailClosed
+
+	// FailOver reads the shortfall of failing sources from a backup source.
+	// Without a backup source it behaves as FailClosed.
+	FailOver
+)
+
+// backupLabel identifies the backup source on metrics and errors.
+const backupLabel = "backup"
+
+// A SourceError records a failure reading from a RandomAggr source.
+type SourceError struct {
+	// Source identifies the failing source.
+	Source string
+	// Err is the error returned by source.
+	Err error
+}
+
+func (e *SourceError) Error() string {
+	return "random source " + e.Source + ": " + e.Err.Error()
+}
+
+// Unwrap returns the error returned by source.
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
 
 // A source defines a source of random data and its weight from total.
 type source struct {
+	// The name of current random source, used on errors and metrics.
+	Name string
 	// The reader of random data.
 	Reader io.Reader
 	// The weight of current random source.
 	Weight int
+	// The lock of reader, shared by sources with the same reader.
+	mutex *sync.Mutex
+	// The counters of current random source.
+	stats *sourceStats
 }
 
-// A RandomAggr represents an aggregation of random data sources.
-type RandomAggr struct {
+// A sourceStats counts bytes read and errors of a source.
+type sourceStats struct {
+	bytes  int64
+	errors int64
+}
+
+// A SourceInfo describes a source of a RandomAggr and its live statistics.
+type SourceInfo struct {
+	// Name identifies the source on errors and metrics.
+	Name string
+	// Weight is the weight of the source, zero for backup source.
+	Weight int
+	// Percent is the percentage of output read from the source, as
+	// apportioned by its weight. Every source contributes to all output
+	// when sources are mixed by XOR or hashing.
+	Percent float64
+	// Bytes is the count of bytes read from the source.
+	Bytes int64
+	// Errors is the count of failed reads from the source.
+	Errors int64
+}
+
+// A sourceSet defines the sources of a RandomAggr, which are replaced as a
+// whole. It is not modified once created.
+type sourceSet struct {
 	sources   []source
 	sumWeight int
+	backup    source
+	// The reads in flight against current set.
+	readers sync.WaitGroup
 }
 
-// Close iterate over io.Closer sources to close them.
-func (s *RandomAggr) Close() error {
+// newSourceSet creates a new set from specified sources and backup source,
+// which may be nil. Sources kept from previous set, which may be nil, share
+// their locks and statistics with it.
+func newSourceSet(sources []source, backup io.Reader, previous *sourceSet) *sourceSet {
+	result := &sourceSet{
+		sources: make([]source, len(sources)),
+		backup:  source{Name: backupLabel, Reader: backup},
+	}
+
+	var kept []source
+	locks := make(map[io.Reader]*sync.Mutex)
+	if previous != nil {
+		kept = previous.all()
+		for _, v := range kept {
+			if comparableReader(v.Reader) {
+				locks[v.Reader] = v.mutex
+			}
+		}
+	}
+
+	lock := func(r io.Reader) *sync.Mutex {
+		if !comparableReader(r) {
+			return &sync.Mutex{}
+		}
+		if _, ok := locks[r]; !ok {
+			locks[r] = &sync.Mutex{}
+		}
+		return locks[r]
+	}
+	stats := func(src source) *sourceStats {
+		for _, v := range kept {
+			if v.Name == src.Name && comparableReader(src.Reader) &&
+				v.Reader == src.Reader {
+				return v.stats
+			}
+		}
+		return &sourceStats{}
+	}
+
+	for i, v := range sources {
+		if v.Name == "" {
+			v.Name = strconv.Itoa(i)
+		}
+		v.mutex = lock(v.Reader)
+		v.stats = stats(v)
+
+		result.sources[i] = v
+		result.sumWeight += v.Weight
+	}
+	result.backup.mutex = lock(backup)
+	result.backup.stats = stats(result.backup)
+
+	return result
+}
+
+// all returns every source of current set, followed by backup source when
+// defined.
+func (set *sourceSet) all() []source {
+	result := make([]source, 0, len(set.sources)+1)
+	result = append(result, set.sources...)
+	if set.backup.Reader != nil {
+		result = append(result, set.backup)
+	}
+
+	return result
+}
+
+// close closes io.Closer sources of current set, except those kept by
+// specified set, which may be nil. Each reader is closed once.
+func (set *sourceSet) close(keep *sourceSet) error {
+	closed := make(map[io.Reader]bool)
+	if keep != nil {
+		for _, v := range keep.all() {
+			if comparableReader(v.Reader) {
+				closed[v.Reader] = true
+			}
+		}
+	}
+
 	var err error
-	for _, v := range s.sources {
+	for _, v := range set.all() {
+		if comparableReader(v.Reader) {
+			if closed[v.Reader] {
+				continue
+			}
+			closed[v.Reader] = true
+		}
+
 		if closer, ok := v.Reader.(io.Closer); ok {
 			itemErr := closer.Close()
 			if err == nil {
@@ -49,25 +230,475 @@ func (s *RandomAggr) Close() error {
 	return err
 }
 
-// Read fills specified byte array with random data from all sources.
+// comparableReader returns whether specified reader can be identified by equality.
+func comparableReader(r io.Reader) bool {
+	return r != nil && reflect.TypeOf(r).Comparable()
+}
+
+// A RandomAggr represents an aggregation of random data sources. It is safe for
+// concurrent use, each source is locked while it is read. Its sources can be
+// replaced while it is used.
+type RandomAggr struct {
+	set      *sourceSet
+	metrics  Metrics
+	mode     MixMode
+	policy   FailurePolicy
+	chunk    int
+	parallel bool
+	closed   bool
+	mutex    *sync.RWMutex
+}
+
+// newRandomAggr creates a new instance of RandomAggr from specified sources
+// and backup source, which may be nil.
+func newRandomAggr(sources []source, backup io.Reader) *RandomAggr {
+	return &RandomAggr{
+		set:     newSourceSet(sources, backup, nil),
+		metrics: nopMetrics{},
+		mutex:   &sync.RWMutex{},
+	}
+}
+
+// Close iterate over io.Closer sources, including backup source, to close them.
+// Further reads return ErrClosed.
+func (s *RandomAggr) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	set := s.set
+	s.mutex.Unlock()
+
+	return set.close(nil)
+}
+
+// ReplaceSources atomically replaces the sources and backup source of current
+// instance by those defined by specified builder, which are validated as by
+// BuildE. Other options of the builder are ignored.
+//
+// Reads started before replacement complete against previous sources.
+// ReplaceSources waits for them and then closes previous io.Closer sources
+// which are not kept. When validation fails, current sources are kept and no
+// source is closed, as by BuildE.
+//
+// Returns ErrClosed when current instance is closed.
+func (s *RandomAggr) ReplaceSources(b RandomAggrBuilder) error {
+	built, err := b.BuildE()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		built.Close()
+		return ErrClosed
+	}
+	previous := s.set
+	s.set = newSourceSet(built.set.sources, built.set.backup.Reader, previous)
+	next := s.set
+	s.mutex.Unlock()
+
+	previous.readers.Wait()
+	return previous.close(next)
+}
+
+// SetMetrics sets the receiver of operational metrics of current instance.
+// Sources are labeled by their name.
+func (s *RandomAggr) SetMetrics(m Metrics) {
+	if m == nil {
+		m = nopMetrics{}
+	}
+
+	s.mutex.Lock()
+	s.metrics = m
+	s.mutex.Unlock()
+}
+
+// Sources returns the description and live statistics of each source,
+// followed by backup source when defined.
+func (s *RandomAggr) Sources() []SourceInfo {
+	set := s.current()
+	result := make([]SourceInfo, 0, len(set.sources)+1)
+	for _, v := range set.sources {
+		percent := 100.0
+		if s.mode == MixPartition {
+			percent = 0
+			if set.sumWeight > 0 {
+				percent = 100 * float64(v.Weight) / float64(set.sumWeight)
+			}
+		}
+
+		result = append(result, SourceInfo{
+			Name:    v.Name,
+			Weight:  v.Weight,
+			Percent: percent,
+			Bytes:   atomic.LoadInt64(&v.stats.bytes),
+			Errors:  atomic.LoadInt64(&v.stats.errors),
+		})
+	}
+
+	if set.backup.Reader != nil {
+		result = append(result, SourceInfo{
+			Name:   set.backup.Name,
+			Bytes:  atomic.LoadInt64(&set.backup.stats.bytes),
+			Errors: atomic.LoadInt64(&set.backup.stats.errors),
+		})
+	}
+
+	return result
+}
+
+// current returns current set of sources.
+func (s *RandomAggr) current() *sourceSet {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.set
+}
+
+// acquire returns current set of sources and registers a read in flight
+// against it, which must be released by calling Done on its readers.
+//
+// Returns ErrClosed when current instance is closed.
+func (s *RandomAggr) acquire() (*sourceSet, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	s.set.readers.Add(1)
+	return s.set, nil
+}
+
+// Read fills specified byte array with random data from all sources, combined
+// as specified by its MixMode. Failing sources are handled as specified by its
+// FailurePolicy. When parallel, sources are read concurrently, except for
+// MixHash mode.
+//
+// Returns a SourceError identifying the source when data cannot be read,
+// ErrNoSources when no source can be read or ErrClosed when current instance
+// is closed.
 func (s *RandomAggr) Read(b []byte) (n int, err error) {
-	remainder := len(b)
-	pos := 0
-	sumWeight := s.sumWeight
+	set, err := s.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer set.readers.Done()
+
+	switch {
+	case s.mode == MixXOR:
+		return s.readXOR(set, b)
+	case s.mode == MixHash:
+		return s.readHash(set, b)
+	case s.parallel:
+		return s.readParallel(set, b)
+	default:
+		return s.readPartition(set, b)
+	}
+}
+
+// readPartition fills disjoint portions of specified byte array from each
+// source, as apportioned by their weights. When interleaving, portions are
+// read in round-robin chunks. Shortfall of a failing source is apportioned
+// again among remaining sources.
+func (s *RandomAggr) readPartition(set *sourceSet, b []byte) (n int, err error) {
+	failed := make([]bool, len(set.sources))
+	shares := set.apportion(len(b), failed)
+
+	for n < len(b) && shares != nil {
+		for i := range set.sources {
+			if shares == nil || shares[i] == 0 {
+				continue
+			}
+
+			count := shares[i]
+			if s.chunk > 0 && count > s.chunk {
+				count = s.chunk
+			}
+
+			c, srcErr := s.fill(set, i, b[n:n+count])
+			n += c
+			shares[i] -= c
+
+			if srcErr != nil {
+				if s.policy != FailRedistribute {
+					return n, srcErr
+				}
+				failed[i] = true
+				err = srcErr
+				shares = set.apportion(len(b)-n, failed)
+			}
+		}
+	}
+
+	if n < len(b) {
+		return n, noSources(err)
+	}
+	return n, nil
+}
+
+// readParallel fills disjoint portions of specified byte array from each
+// source, as apportioned by their weights, reading all sources concurrently.
+// Data is laid out as by readPartition.
+func (s *RandomAggr) readParallel(set *sourceSet, b []byte) (n int, err error) {
+	failed := make([]bool, len(set.sources))
+
+	for n < len(b) {
+		shares := set.apportion(len(b)-n, failed)
+		if shares == nil {
+			return n, noSources(err)
+		}
+
+		bufs := make([][]byte, len(shares))
+		for i, v := range shares {
+			bufs[i] = make([]byte, v)
+		}
+		errs := s.fetch(set, bufs)
+
+		n += s.layout(b[n:], bufs)
+		for i, srcErr := range errs {
+			if srcErr == nil {
+				continue
+			}
+			if s.policy != FailRedistribute {
+				return n, srcErr
+			}
+			failed[i] = true
+			err = srcErr
+		}
+	}
 
-	for _, v := range s.sources {
-		count := int(float32(remainder) * (float32(v.Weight) / float32(sumWeight)))
-		n, err = io.ReadFull(v.Reader, b[pos:pos+count])
-		if err != nil && err != io.ErrUnexpectedEOF {
-			return
+	return n, nil
+}
+
+// fetch fills each buffer from source with the same index concurrently.
+// Buffers are truncated to the count read. Returns the error of each source.
+func (s *RandomAggr) fetch(set *sourceSet, bufs [][]byte) []error {
+	errs := make([]error, len(bufs))
+	var wg sync.WaitGroup
+
+	for i := range bufs {
+		if len(bufs[i]) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var c int
+			c, errs[i] = s.fill(set, i, bufs[i])
+			bufs[i] = bufs[i][:c]
+		}(i)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// layout copies buffers into specified byte array, one after another or in
+// round-robin chunks when interleaving. Returns the count of bytes copied.
+func (s *RandomAggr) layout(b []byte, bufs [][]byte) (n int) {
+	for done := false; !done; {
+		done = true
+		for i, v := range bufs {
+			if len(v) == 0 {
+				continue
+			}
+
+			count := len(v)
+			if s.chunk > 0 && count > s.chunk {
+				count = s.chunk
+			}
+			n += copy(b[n:], v[:count])
+			bufs[i] = v[count:]
+			done = false
+		}
+	}
+
+	return n
+}
+
+// apportion splits total bytes among sources which have not failed in
+// proportion to their weights, using exact integer largest remainder method.
+// Returns nil when no such source has a positive weight.
+func (set *sourceSet) apportion(total int, failed []bool) []int {
+	var sumWeight uint64
+	for i, v := range set.sources {
+		if !failed[i] && v.Weight > 0 {
+			sumWeight += uint64(v.Weight)
 		}
+	}
+	if sumWeight == 0 {
+		return nil
+	}
+
+	shares := make([]int, len(set.sources))
+	remainders := make([]uint64, len(set.sources))
+	left := total
+	for i, v := range set.sources {
+		if failed[i] || v.Weight <= 0 {
+			continue
+		}
+
+		hi, lo := bits.Mul64(uint64(total), uint64(v.Weight))
+		quo, rem := bits.Div64(hi, lo, sumWeight)
+		shares[i] = int(quo)
+		remainders[i] = rem
+		left -= int(quo)
+	}
+
+	// Sum of remainders equals left times sumWeight, so there are always
+	// more than left sources with nonzero remainder.
+	for ; left > 0; left-- {
+		largest := -1
+		for i, v := range remainders {
+			if v > 0 && (largest < 0 || v > remainders[largest]) {
+				largest = i
+			}
+		}
+
+		shares[largest]++
+		remainders[largest] = 0
+	}
+
+	return shares
+}
+
+// readXOR fills specified byte array from each source and XOR them together.
+// When redistributing, bytes which a failing source cannot deliver are
+// combined only from remaining sources.
+func (s *RandomAggr) readXOR(set *sourceSet, b []byte) (n int, err error) {
+	bufs := make([][]byte, len(set.sources))
+	errs := make([]error, len(set.sources))
+	for i := range bufs {
+		bufs[i] = make([]byte, len(b))
+	}
+
+	if s.parallel {
+		errs = s.fetch(set, bufs)
+	} else {
+		for i := range bufs {
+			var c int
+			c, errs[i] = s.fill(set, i, bufs[i])
+			bufs[i] = bufs[i][:c]
+			if errs[i] != nil && s.policy != FailRedistribute {
+				return 0, errs[i]
+			}
+		}
+	}
+
+	zero(b)
+	for i, buf := range bufs {
+		if srcErr := errs[i]; srcErr != nil {
+			if s.policy != FailRedistribute {
+				return 0, srcErr
+			}
+			err = srcErr
+		}
+
+		for j, v := range buf {
+			b[j] ^= v
+		}
+		if len(buf) > n {
+			n = len(buf)
+		}
+	}
+
+	if n == len(b) {
+		return n, nil
+	}
+	return n, noSources(err)
+}
+
+// readHash fills specified byte array by hashing together blocks read from
+// each source with a block counter. When redistributing, failing sources are
+// left out of next blocks.
+func (s *RandomAggr) readHash(set *sourceSet, b []byte) (n int, err error) {
+	buf := make([]byte, sha256.Size)
+	failed := make([]bool, len(set.sources))
+	var counter [8]byte
+
+	for n < len(b) {
+		hash := sha256.New()
+		binary.LittleEndian.PutUint64(counter[:], uint64(n/sha256.Size))
+		hash.Write(counter[:])
+
+		mixed := 0
+		for i := range set.sources {
+			if failed[i] {
+				continue
+			}
+
+			if _, srcErr := s.fill(set, i, buf); srcErr != nil {
+				if s.policy != FailRedistribute {
+					return n, srcErr
+				}
+				failed[i] = true
+				err = srcErr
+				continue
+			}
+			hash.Write(buf)
+			mixed++
+		}
+		if mixed == 0 {
+			return n, noSources(err)
+		}
+
+		n += copy(b[n:], hash.Sum(buf[:0]))
+	}
+
+	return n, nil
+}
+
+// noSources returns specified error of last failing source, or ErrNoSources
+// when no source was read.
+func noSources(err error) error {
+	if err == nil {
+		return ErrNoSources
+	}
+	return err
+}
+
+// fill fills specified byte array from source at index i. When failing over,
+// shortfall is read from backup source.
+func (s *RandomAggr) fill(set *sourceSet, i int, b []byte) (int, error) {
+	n, err := s.read(set.sources[i], b)
+	if err == nil || s.policy != FailOver || set.backup.Reader == nil {
+		return n, err
+	}
+
+	c, err := s.read(set.backup, b[n:])
+	return n + c, err
+}
+
+// read fills specified byte array from src, while holding its lock, and
+// reports its statistics and metrics.
+func (s *RandomAggr) read(src source, b []byte) (int, error) {
+	src.mutex.Lock()
+	n, err := io.ReadFull(src.Reader, b)
+	src.mutex.Unlock()
+
+	atomic.AddInt64(&src.stats.bytes, int64(n))
+	if err != nil {
+		atomic.AddInt64(&src.stats.errors, 1)
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 
-		pos += n
-		remainder -= n
-		sumWeight -= v.Weight
+	s.metrics.Add(MetricRandomAggrBytes, src.Name, int64(n))
+	if err != nil {
+		s.metrics.Add(MetricRandomAggrErrors, src.Name, 1)
+		return n, &SourceError{src.Name, err}
 	}
 
-	return len(b) - remainder, nil
+	return n, nil
 }
 
 var _ io.ReadCloser = (*RandomAggr)(nil)
@@ -19,8 +19,15 @@
 package crypt
 
 import (
+	"bytes"
+	"errors"
 	"io"
+	"math"
+	"math/big"
+	"math/rand"
+	"sync"
 	"testing"
+	"time"
 )
 
 type InfiniteSource int
@@ -88,8 +95,8 @@ func TestRandomAggrDistribution(t *testing.T) {
 	}
 
 	testValues(buf[:10], 1, t)
-	testValues(buf[10:43], 2, t)
-	testValues(buf[43:], 3, t)
+	testValues(buf[10:44], 2, t)
+	testValues(buf[44:], 3, t)
 }
 
 func TestRandomAggrDistribution2(t *testing.T) {
@@ -122,8 +129,8 @@ func TestRandomAggrInsufficient(t *testing.T) {
 
 	buf := make([]byte, 100)
 	n, err := rnd.Read(buf)
-	if err != nil {
-		t.Fatalf("Error reading from aggregation: %v", err)
+	if _, ok := err.(*SourceError); !ok {
+		t.Errorf("Should fail when all sources are exhausted: got %v", err)
 	}
 	if n != 60 {
 		t.Errorf("Should read 60 bytes: read %d bytes", n)
@@ -194,6 +201,721 @@ func TestRandomAggrRepeatingDecimal2(t *testing.T) {
 	testValues(buf[1:], 2, t)
 }
 
+func TestRandomAggrLargestRemainder(t *testing.T) {
+	rnd := NewRandomAggr().
+		Add(InfiniteSource(1), 1).
+		Add(InfiniteSource(2), 1).
+		Add(InfiniteSource(3), 1).
+		Build()
+
+	buf := make([]byte, 5)
+	rnd.Read(buf)
+	testValues(buf[:2], 1, t)
+	testValues(buf[2:4], 2, t)
+	testValues(buf[4:], 3, t)
+}
+
+func TestRandomAggrApportion(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for round := 0; round < 200; round++ {
+		weights := make([]int, 1+rng.Intn(8))
+		builder := NewRandomAggr()
+		for i := range weights {
+			weights[i] = 1 + rng.Intn(1000)
+			builder.Add(InfiniteSource(i+1), weights[i])
+		}
+		rnd := builder.Build()
+
+		for _, size := range []int{0, 1, 7, 64, 1000, rng.Intn(1 << 16)} {
+			buf := make([]byte, size)
+			n, err := rnd.Read(buf)
+			if err != nil || n != size {
+				t.Fatalf("Should fill entire buffer: read %d of %d bytes: %v",
+					n, size, err)
+			}
+
+			counts := countByValue(buf)
+			shares := make([]int, len(weights))
+			for i := range shares {
+				shares[i] = counts[byte(i+1)]
+			}
+			checkApportion(t, size, weights, shares)
+		}
+	}
+}
+
+func TestRandomAggrApportionOverflow(t *testing.T) {
+	weights := []int{math.MaxInt / 3, math.MaxInt / 3, math.MaxInt / 5}
+	rnd := NewRandomAggr().
+		Add(InfiniteSource(1), weights[0]).
+		Add(InfiniteSource(2), weights[1]).
+		Add(InfiniteSource(3), weights[2]).
+		Build()
+
+	shares := rnd.set.apportion(1<<20, make([]bool, len(weights)))
+	checkApportion(t, 1<<20, weights, shares)
+}
+
+// checkApportion checks whether shares are apportioned from size by largest
+// remainder method.
+func checkApportion(t *testing.T, size int, weights, shares []int) {
+	sumWeight := new(big.Int)
+	for _, v := range weights {
+		sumWeight.Add(sumWeight, big.NewInt(int64(v)))
+	}
+
+	total := 0
+	extra := 0
+	minExtra := new(big.Int)
+	maxFloor := big.NewInt(-1)
+	for i, v := range weights {
+		quota := new(big.Int).Mul(big.NewInt(int64(size)), big.NewInt(int64(v)))
+		quo, rem := new(big.Int).QuoRem(quota, sumWeight, new(big.Int))
+
+		switch int64(shares[i]) - quo.Int64() {
+		case 0:
+			if rem.Cmp(maxFloor) > 0 {
+				maxFloor = rem
+			}
+		case 1:
+			extra++
+			if extra == 1 || rem.Cmp(minExtra) < 0 {
+				minExtra = rem
+			}
+		default:
+			t.Fatalf("Source %d of %v: got share %d of %d bytes",
+				i, weights, shares[i], size)
+		}
+		total += shares[i]
+	}
+
+	if total != size {
+		t.Errorf("Shares %v should sum %d bytes", shares, size)
+	}
+	if extra > 0 && minExtra.Cmp(maxFloor) < 0 {
+		t.Errorf("Shares %v of %d bytes should favor largest remainders",
+			shares, size)
+	}
+}
+
+func TestRandomAggrInterleave(t *testing.T) {
+	rnd := NewRandomAggr().
+		Add(InfiniteSource(1), 1).
+		Add(InfiniteSource(2), 3).
+		Interleave(4).
+		Build()
+
+	buf := make([]byte, 32)
+	n, err := rnd.Read(buf)
+	if err != nil || n != len(buf) {
+		t.Fatalf("Should fill entire buffer: read %d bytes: %v", n, err)
+	}
+
+	expected := []byte{1, 2, 1, 2, 2, 2, 2}
+	for i, v := range expected {
+		testValues(buf[i*4:i*4+4], v, t)
+	}
+}
+
+func TestRandomAggrInterleaveLayout(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+
+	for round := 0; round < 200; round++ {
+		chunk := 1 + rng.Intn(64)
+		weights := make([]int, 2+rng.Intn(6))
+		builder := NewRandomAggr().Interleave(chunk)
+		for i := range weights {
+			weights[i] = 1 + rng.Intn(100)
+			builder.Add(InfiniteSource(i+1), weights[i])
+		}
+
+		size := rng.Intn(1 << 14)
+		buf := make([]byte, size)
+		if n, err := builder.Build().Read(buf); err != nil || n != size {
+			t.Fatalf("Should fill entire buffer: read %d bytes: %v", n, err)
+		}
+
+		last := make(map[byte]int)
+		for i, v := range buf {
+			last[v] = i
+		}
+
+		// A run longer than a chunk is only allowed after every other source
+		// has delivered its share.
+		for start := 0; start < len(buf); {
+			end := start
+			for end < len(buf) && buf[end] == buf[start] {
+				end++
+			}
+
+			if end-start > chunk {
+				for v, pos := range last {
+					if v != buf[start] && pos > start {
+						t.Fatalf("Chunk %d: run of %d bytes at %d for weights %v",
+							chunk, end-start, start, weights)
+					}
+				}
+			}
+			start = end
+		}
+	}
+}
+
+func TestRandomAggrInterleaveRedistribute(t *testing.T) {
+	rnd := NewRandomAggr().
+		Add(&LimitedSource{1, 6}, 1).
+		Add(InfiniteSource(2), 1).
+		Interleave(4).
+		Build()
+
+	buf := make([]byte, 20)
+	n, err := rnd.Read(buf)
+	if err != nil || n != len(buf) {
+		t.Fatalf("Should fill entire buffer: read %d bytes: %v", n, err)
+	}
+
+	testValues(buf[:4], 1, t)
+	testValues(buf[4:8], 2, t)
+	testValues(buf[8:10], 1, t)
+	testValues(buf[10:], 2, t)
+}
+
+func TestRandomAggrXOR(t *testing.T) {
+	rnd := NewRandomAggr().
+		Add(InfiniteSource(1), 1).
+		Add(InfiniteSource(2), 5).
+		Add(InfiniteSource(4), 3).
+		Mixing(MixXOR).
+		Build()
+
+	buf := make([]byte, 100)
+	n, err := rnd.Read(buf)
+	if err != nil {
+		t.Fatalf("Error reading from aggregation: %v", err)
+	}
+	if n != len(buf) {
+		t.Errorf("Should fill entire buffer: read %d bytes", n)
+	}
+
+	testValues(buf, 1^2^4, t)
+}
+
+func TestRandomAggrXORInsufficient(t *testing.T) {
+	rnd := NewRandomAggr().
+		Add(InfiniteSource(1), 1).
+		Add(&LimitedSource{2, 30}, 1).
+		Mixing(MixXOR).
+		Build()
+
+	buf := make([]byte, 100)
+	n, err := rnd.Read(buf)
+	if err != nil {
+		t.Fatalf("Error reading from aggregation: %v", err)
+	}
+	if n != len(buf) {
+		t.Errorf("Should fill entire buffer: read %d bytes", n)
+	}
+
+	testValues(buf[:30], 1^2, t)
+	testValues(buf[30:], 1, t)
+}
+
+func TestRandomAggrHash(t *testing.T) {
+	read := func(val int) []byte {
+		rnd := NewRandomAggr().
+			Add(InfiniteSource(1), 1).
+			Add(InfiniteSource(val), 1).
+			Mixing(MixHash).
+			Build()
+
+		buf := make([]byte, 100)
+		n, err := rnd.Read(buf)
+		if err != nil {
+			t.Fatalf("Error reading from aggregation: %v", err)
+		}
+		if n != len(buf) {
+			t.Errorf("Should fill entire buffer: read %d bytes", n)
+		}
+		return buf
+	}
+
+	first := read(2)
+	second := read(3)
+	if bytes.Equal(first[:32], first[32:64]) {
+		t.Error("Consecutive blocks should differ")
+	}
+
+	diff := 0
+	for i := range first {
+		if first[i] != second[i] {
+			diff++
+		}
+	}
+	if diff < len(first)*9/10 {
+		t.Errorf("Every output byte should depend on all sources: %d of %d differ",
+			diff, len(first))
+	}
+}
+
+func TestRandomAggrHashInsufficient(t *testing.T) {
+	rnd := NewRandomAggr().
+		Add(InfiniteSource(1), 1).
+		Add(&LimitedSource{2, 40}, 1).
+		Mixing(MixHash).
+		Build()
+
+	n, err := rnd.Read(make([]byte, 100))
+	if err != nil {
+		t.Fatalf("Error reading from aggregation: %v", err)
+	}
+	if n != 100 {
+		t.Errorf("Should fill entire buffer: read %d bytes", n)
+	}
+
+	rnd = NewRandomAggr().
+		Add(&LimitedSource{1, 40}, 1).
+		Add(&LimitedSource{2, 40}, 1).
+		Mixing(MixHash).
+		Build()
+
+	n, err = rnd.Read(make([]byte, 100))
+	if _, ok := err.(*SourceError); !ok {
+		t.Errorf("Should fail when all sources are exhausted: got %v", err)
+	}
+	if n != 32 {
+		t.Errorf("Should read only complete blocks: read %d bytes", n)
+	}
+}
+
+// A FailingSource returns specified error after delivering size bytes.
+type FailingSource struct {
+	val  int
+	size int
+	err  error
+}
+
+func (s *FailingSource) Read(b []byte) (int, error) {
+	if s.size <= 0 {
+		return 0, s.err
+	}
+
+	n := len(b)
+	if n > s.size {
+		n = s.size
+	}
+	for i := range b[:n] {
+		b[i] = byte(s.val)
+	}
+	s.size -= n
+
+	return n, nil
+}
+
+func TestRandomAggrFailClosed(t *testing.T) {
+	for _, mode := range []MixMode{MixPartition, MixXOR, MixHash} {
+		rnd := NewRandomAggr().
+			Add(InfiniteSource(1), 1).
+			Add(&LimitedSource{2, 10}, 1).
+			Mixing(mode).
+			Failure(FailClosed).
+			Build()
+
+		_, err := rnd.Read(make([]byte, 100))
+		srcErr, ok := err.(*SourceError)
+		if !ok {
+			t.Fatalf("Mode %d: should return SourceError: got %v", mode, err)
+		}
+		if srcErr.Source != "1" || !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Mode %d: unexpected error %v", mode, err)
+		}
+	}
+}
+
+func TestRandomAggrFailClosedError(t *testing.T) {
+	fail := errors.New("device unplugged")
+	rnd := NewRandomAggr().
+		Add(&FailingSource{1, 5, fail}, 1).
+		Add(InfiniteSource(2), 1).
+		Failure(FailClosed).
+		Build()
+
+	buf := make([]byte, 100)
+	n, err := rnd.Read(buf)
+	if !errors.Is(err, fail) {
+		t.Errorf("Should return source error: got %v", err)
+	}
+	if err.Error() != "random source 0: device unplugged" {
+		t.Errorf("Should identify failing source: got %q", err.Error())
+	}
+	if n != 5 {
+		t.Errorf("Should read 5 bytes: read %d bytes", n)
+	}
+}
+
+func TestRandomAggrRedistribute(t *testing.T) {
+	rnd := NewRandomAggr().
+		Add(InfiniteSource(1), 1).
+		Add(InfiniteSource(2), 1).
+		Add(&FailingSource{3, 10, errors.New("failed")}, 2).
+		Build()
+
+	buf := make([]byte, 100)
+	n, err := rnd.Read(buf)
+	if err != nil {
+		t.Fatalf("Error reading from aggregation: %v", err)
+	}
+	if n != len(buf) {
+		t.Errorf("Should fill entire buffer: read %d bytes", n)
+	}
+
+	testValues(buf[:25], 1, t)
+	testValues(buf[25:50], 2, t)
+	testValues(buf[50:60], 3, t)
+	testValues(buf[60:80], 1, t)
+	testValues(buf[80:], 2, t)
+}
+
+func TestRandomAggrFailOver(t *testing.T) {
+	for _, mode := range []MixMode{MixPartition, MixXOR} {
+		backup := &LimitedSource{9, 100}
+		rnd := NewRandomAggr().
+			Add(&LimitedSource{1, 10}, 1).
+			Add(InfiniteSource(2), 1).
+			Mixing(mode).
+			Failure(FailOver).
+			Backup(backup).
+			Build()
+
+		buf := make([]byte, 100)
+		n, err := rnd.Read(buf)
+		if err != nil {
+			t.Fatalf("Mode %d: error reading from aggregation: %v", mode, err)
+		}
+		if n != len(buf) {
+			t.Errorf("Mode %d: should fill entire buffer: read %d bytes",
+				mode, n)
+		}
+
+		if mode == MixPartition {
+			testValues(buf[:10], 1, t)
+			testValues(buf[10:50], 9, t)
+			testValues(buf[50:], 2, t)
+		} else {
+			testValues(buf[:10], 1^2, t)
+			testValues(buf[10:], 9^2, t)
+		}
+	}
+
+	rnd := NewRandomAggr().
+		Add(&LimitedSource{1, 10}, 1).
+		Failure(FailOver).
+		Backup(&LimitedSource{9, 10}).
+		Build()
+
+	_, err := rnd.Read(make([]byte, 100))
+	if err, ok := err.(*SourceError); !ok || err.Source != backupLabel {
+		t.Errorf("Should fail when backup source fails: got %v", err)
+	}
+}
+
+// A CountingSource counts bytes read without any synchronization.
+type CountingSource struct {
+	count int
+}
+
+func (s *CountingSource) Read(b []byte) (int, error) {
+	for i := range b {
+		s.count++
+		b[i] = byte(s.count)
+	}
+
+	return len(b), nil
+}
+
+// A BarrierSource blocks each read until all sources sharing its barrier are
+// being read.
+type BarrierSource struct {
+	val     int
+	arrived *sync.WaitGroup
+}
+
+func (s *BarrierSource) Read(b []byte) (int, error) {
+	s.arrived.Done()
+
+	ready := make(chan bool)
+	go func() {
+		s.arrived.Wait()
+		close(ready)
+	}()
+
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		return 0, errors.New("sources are not read concurrently")
+	}
+
+	for i := range b {
+		b[i] = byte(s.val)
+	}
+	return len(b), nil
+}
+
+// A LatencySource delivers data after a fixed delay.
+type LatencySource time.Duration
+
+func (s LatencySource) Read(b []byte) (int, error) {
+	time.Sleep(time.Duration(s))
+	return len(b), nil
+}
+
+func TestRandomAggrConcurrent(t *testing.T) {
+	shared := &CountingSource{}
+	other := &CountingSource{}
+	for _, parallel := range []bool{false, true} {
+		shared.count, other.count = 0, 0
+		rnd := NewRandomAggr().
+			Add(shared, 1).
+			Add(other, 2).
+			Add(shared, 1).
+			Parallel(parallel).
+			Build()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				buf := make([]byte, 100)
+				for j := 0; j < 50; j++ {
+					if _, err := rnd.Read(buf); err != nil {
+						t.Errorf("Error reading from aggregation: %v", err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		if shared.count != 8*50*50 || other.count != 8*50*50 {
+			t.Errorf("Parallel %v: unexpected counts %d and %d",
+				parallel, shared.count, other.count)
+		}
+	}
+}
+
+func TestRandomAggrParallel(t *testing.T) {
+	arrived := &sync.WaitGroup{}
+	arrived.Add(3)
+	rnd := NewRandomAggr().
+		Add(&BarrierSource{1, arrived}, 1).
+		Add(&BarrierSource{2, arrived}, 1).
+		Add(&BarrierSource{3, arrived}, 2).
+		Parallel(true).
+		Build()
+
+	buf := make([]byte, 100)
+	n, err := rnd.Read(buf)
+	if err != nil || n != len(buf) {
+		t.Fatalf("Should fill entire buffer: read %d bytes: %v", n, err)
+	}
+
+	testValues(buf[:25], 1, t)
+	testValues(buf[25:50], 2, t)
+	testValues(buf[50:], 3, t)
+}
+
+func TestRandomAggrParallelLayout(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+
+	for round := 0; round < 100; round++ {
+		chunk := rng.Intn(16)
+		weights := make([]int, 1+rng.Intn(6))
+		for i := range weights {
+			weights[i] = 1 + rng.Intn(100)
+		}
+
+		read := func(parallel bool) []byte {
+			builder := NewRandomAggr().Interleave(chunk).Parallel(parallel)
+			for i, v := range weights {
+				builder.Add(InfiniteSource(i+1), v)
+			}
+
+			buf := make([]byte, rng.Intn(4096))
+			if n, err := builder.Build().Read(buf); err != nil || n != len(buf) {
+				t.Fatalf("Should fill entire buffer: read %d bytes: %v", n, err)
+			}
+			return buf
+		}
+
+		seed := rng.Int63()
+		rng.Seed(seed)
+		sequential := read(false)
+		rng.Seed(seed)
+		if !bytes.Equal(sequential, read(true)) {
+			t.Fatalf("Parallel layout should match sequential for weights %v",
+				weights)
+		}
+	}
+}
+
+func TestRandomAggrParallelFailure(t *testing.T) {
+	rnd := NewRandomAggr().
+		Add(&LimitedSource{1, 10}, 2).
+		Add(InfiniteSource(2), 3).
+		Add(InfiniteSource(3), 5).
+		Parallel(true).
+		Build()
+
+	buf := make([]byte, 100)
+	n, err := rnd.Read(buf)
+	if err != nil || n != len(buf) {
+		t.Fatalf("Should fill entire buffer: read %d bytes: %v", n, err)
+	}
+	if count := countByValue(buf)[1]; count != 10 {
+		t.Errorf("Should read 10 bytes from limited source: read %d", count)
+	}
+
+	rnd = NewRandomAggr().
+		Add(&LimitedSource{1, 10}, 1).
+		Add(InfiniteSource(2), 1).
+		Parallel(true).
+		Failure(FailClosed).
+		Build()
+
+	_, err = rnd.Read(buf)
+	if err, ok := err.(*SourceError); !ok || err.Source != "0" {
+		t.Errorf("Should fail identifying source: got %v", err)
+	}
+}
+
+func TestRandomAggrSources(t *testing.T) {
+	rnd := NewRandomAggr().
+		AddNamed("primary", &LimitedSource{1, 10}, 1).
+		Add(InfiniteSource(2), 3).
+		Backup(InfiniteSource(9)).
+		Failure(FailOver).
+		Build()
+	reg := NewMetricsRegistry()
+	rnd.SetMetrics(reg)
+
+	if _, err := rnd.Read(make([]byte, 100)); err != nil {
+		t.Fatalf("Error reading from aggregation: %v", err)
+	}
+
+	expected := []SourceInfo{
+		{"primary", 1, 25, 10, 1},
+		{"1", 3, 75, 75, 0},
+		{"backup", 0, 0, 15, 0},
+	}
+	sources := rnd.Sources()
+	if len(sources) != len(expected) {
+		t.Fatalf("Unexpected sources: %v", sources)
+	}
+	for i, v := range expected {
+		if sources[i] != v {
+			t.Errorf("Source %d: expected %v, got %v", i, v, sources[i])
+		}
+	}
+
+	if v := reg.Value(MetricRandomAggrErrors, "primary"); v != 1 {
+		t.Errorf("Metrics should be labeled by name: got %d errors", v)
+	}
+
+	rnd = NewRandomAggr().
+		AddNamed("hwrng", &LimitedSource{1, 10}, 1).
+		AddSys(1).
+		Mixing(MixXOR).
+		Failure(FailClosed).
+		Build()
+
+	_, err := rnd.Read(make([]byte, 100))
+	if err == nil || err.Error() != "random source hwrng: unexpected EOF" {
+		t.Errorf("Error should identify source by name: got %v", err)
+	}
+	for _, v := range rnd.Sources() {
+		if v.Percent != 100 {
+			t.Errorf("Source %s should contribute to all output: got %.0f%%",
+				v.Name, v.Percent)
+		}
+	}
+
+	_, err = NewRandomAggr().AddNamed("broken", nil, 1).BuildE()
+	if err, ok := err.(*SourceError); !ok || err.Source != "broken" {
+		t.Errorf("Validation error should identify source by name: got %v",
+			err)
+	}
+}
+
+func TestRandomAggrNoSources(t *testing.T) {
+	tests := []RandomAggrBuilder{
+		NewRandomAggr(),
+		NewRandomAggr().Parallel(true),
+		NewRandomAggr().Mixing(MixXOR),
+		NewRandomAggr().Mixing(MixHash),
+		NewRandomAggr().Add(InfiniteSource(1), 0),
+		NewRandomAggr().Add(InfiniteSource(1), 0).Parallel(true),
+	}
+
+	for i, v := range tests {
+		if _, err := v.BuildE(); err == nil {
+			t.Errorf("Test %d: BuildE should fail", i)
+		}
+
+		rnd := v.Build()
+		n, err := rnd.Read(make([]byte, 10))
+		if n != 0 || err != ErrNoSources {
+			t.Errorf("Test %d: should return ErrNoSources: read %d bytes: %v",
+				i, n, err)
+		}
+
+		for _, info := range rnd.Sources() {
+			if info.Percent != 0 {
+				t.Errorf("Test %d: unexpected percent %v", i, info.Percent)
+			}
+		}
+	}
+}
+
+func TestRandomAggrSourcesNames(t *testing.T) {
+	rnd := NewRandomAggr().AddSys(1).AddJitter(1).Build()
+	defer rnd.Close()
+
+	names := []string{"sys", "jitter"}
+	for i, v := range rnd.Sources() {
+		if v.Name != names[i] {
+			t.Errorf("Source %d: expected name %s, got %s", i, names[i], v.Name)
+		}
+	}
+}
+
+func TestRandomAggrConstantSource(t *testing.T) {
+	for _, mode := range []MixMode{MixXOR, MixHash} {
+		rnd := NewRandomAggr().
+			AddSys(8).
+			Add(InfiniteSource(7), 1).
+			Mixing(mode).
+			Build()
+
+		dups, stddev := testUnpred(rnd)
+		if dups > MaximumDups {
+			t.Errorf("Mode %d: constant source biased output: %d dups of %d",
+				mode, int(TestingRounds*dups), TestingRounds)
+		}
+		if stddev < MinimumStandardDeviation {
+			t.Errorf("Mode %d: constant source biased output: %.2f STDDEV",
+				mode, stddev)
+		}
+
+		buf := make([]byte, 1000)
+		rnd.Read(buf)
+		if count := countByValue(buf)[7]; count > 20 {
+			t.Errorf("Mode %d: constant value found %d times of %d",
+				mode, count, len(buf))
+		}
+	}
+}
+
 func testValues(b []byte, expected byte, t *testing.T) {
 	for _, v := range b {
 		if v != expected {
@@ -201,3 +923,257 @@ func testValues(b []byte, expected byte, t *testing.T) {
 		}
 	}
 }
+
+// A gateSource signals each read and blocks it until released.
+type gateSource struct {
+	closingSource
+	reading chan bool
+	release chan bool
+}
+
+func (s *gateSource) Read(b []byte) (int, error) {
+	s.reading <- true
+	<-s.release
+	return s.closingSource.Read(b)
+}
+
+func TestRandomAggrReplaceSources(t *testing.T) {
+	kept := &closingSource{InfiniteSource: 1}
+	dropped := &closingSource{InfiniteSource: 2}
+	rnd := NewRandomAggr().
+		AddNamed("kept", kept, 1).
+		AddNamed("dropped", dropped, 1).
+		Build()
+
+	buf := make([]byte, 10)
+	rnd.Read(buf)
+
+	added := &closingSource{InfiniteSource: 3}
+	err := rnd.ReplaceSources(NewRandomAggr().
+		AddNamed("kept", kept, 1).
+		AddNamed("added", added, 3))
+	if err != nil {
+		t.Fatalf("Error replacing sources: %v", err)
+	}
+
+	if kept.closed || added.closed {
+		t.Error("Kept and added sources should not be closed")
+	}
+	if !dropped.closed {
+		t.Error("Dropped source should be closed")
+	}
+
+	rnd.Read(make([]byte, 8))
+	counts := countByValue(buf)
+	if counts[1] != 5 || counts[2] != 5 {
+		t.Errorf("Unexpected distribution before replacement: %v", counts)
+	}
+
+	info := rnd.Sources()
+	if len(info) != 2 || info[0].Name != "kept" || info[1].Name != "added" {
+		t.Fatalf("Should describe new sources: got %v", info)
+	}
+	if info[0].Bytes != 7 || info[1].Bytes != 6 {
+		t.Errorf("Kept source should keep its statistics: got %v", info)
+	}
+}
+
+func TestRandomAggrReplaceSourcesInvalid(t *testing.T) {
+	shared := &closingSource{InfiniteSource: 1}
+	rnd := NewRandomAggr().Add(shared, 1).Build()
+
+	tests := []struct {
+		builder RandomAggrBuilder
+		err     error
+	}{
+		{NewRandomAggr(), ErrNoSources},
+		{NewRandomAggr().Add(nil, 1), ErrNilSource},
+		{NewRandomAggr().Add(InfiniteSource(2), 0), ErrInvalidWeight},
+		{
+			NewRandomAggr().Add(shared, 1).Add(InfiniteSource(2), 0),
+			ErrInvalidWeight,
+		},
+	}
+	for i, v := range tests {
+		if err := rnd.ReplaceSources(v.builder); !errors.Is(err, v.err) {
+			t.Errorf("Test %d: should return %v: got %v", i, v.err, err)
+		}
+	}
+
+	if shared.closed {
+		t.Error("Sources kept by current instance should not be closed")
+	}
+
+	buf := make([]byte, 10)
+	if _, err := rnd.Read(buf); err != nil {
+		t.Fatalf("Should read after failed replacement: %v", err)
+	}
+	if counts := countByValue(buf); counts[1] != 10 {
+		t.Errorf("Sources should not be replaced on error: got %v", counts)
+	}
+}
+
+func TestRandomAggrClosed(t *testing.T) {
+	src := &closingSource{InfiniteSource: 1}
+	rnd := NewRandomAggr().Add(src, 1).Build()
+
+	if err := rnd.Close(); err != nil {
+		t.Fatalf("Error closing: %v", err)
+	}
+	if !src.closed {
+		t.Error("Should close sources")
+	}
+	if err := rnd.Close(); err != nil {
+		t.Errorf("Close should be idempotent: got %v", err)
+	}
+
+	if _, err := rnd.Read(make([]byte, 1)); err != ErrClosed {
+		t.Errorf("Read should return ErrClosed: got %v", err)
+	}
+	if err := rnd.AddEntropy([]byte("event"), 8); err != ErrClosed {
+		t.Errorf("AddEntropy should return ErrClosed: got %v", err)
+	}
+
+	added := &closingSource{InfiniteSource: 2}
+	err := rnd.ReplaceSources(NewRandomAggr().Add(added, 1))
+	if err != ErrClosed {
+		t.Errorf("ReplaceSources should return ErrClosed: got %v", err)
+	}
+	if !added.closed {
+		t.Error("Sources built after close should be closed")
+	}
+}
+
+func TestRandomAggrReplaceSourcesInFlight(t *testing.T) {
+	old := &gateSource{
+		closingSource: closingSource{InfiniteSource: 1},
+		reading:       make(chan bool),
+		release:       make(chan bool),
+	}
+	rnd := NewRandomAggr().Add(old, 1).Build()
+
+	inflight := make([]byte, 10)
+	readDone := make(chan bool)
+	go func() {
+		rnd.Read(inflight)
+		close(readDone)
+	}()
+	<-old.reading
+
+	replaced := make(chan error)
+	go func() {
+		replaced <- rnd.ReplaceSources(
+			NewRandomAggr().AddNamed("new", InfiniteSource(2), 1))
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for rnd.Sources()[0].Name != "new" {
+		if time.Now().After(deadline) {
+			t.Fatal("Sources should be replaced")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// New reads use new sources while previous read is in flight
+	buf := make([]byte, 10)
+	rnd.Read(buf)
+	if counts := countByValue(buf); counts[2] != 10 {
+		t.Errorf("New reads should use new sources: got %v", counts)
+	}
+
+	select {
+	case <-replaced:
+		t.Fatal("Replacement should wait for reads in flight")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(old.release)
+	<-readDone
+	if err := <-replaced; err != nil {
+		t.Errorf("Error replacing sources: %v", err)
+	}
+	if counts := countByValue(inflight); counts[1] != 10 {
+		t.Errorf("Read in flight should complete from old sources: got %v",
+			counts)
+	}
+	if !old.closed {
+		t.Error("Old source should be closed once drained")
+	}
+}
+
+func TestRandomAggrReplaceSourcesConcurrent(t *testing.T) {
+	shared := &CountingSource{}
+	rnd := NewRandomAggr().Add(shared, 1).Build()
+
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, DefaultTokenSize)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if n, err := rnd.Read(buf); err != nil || n != len(buf) {
+					t.Errorf("Error reading: got %d and %v", n, err)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 100; i++ {
+		builder := NewRandomAggr().Add(shared, 1)
+		if i%2 == 0 {
+			builder.Add(&CountingSource{}, 1)
+		}
+		if err := rnd.ReplaceSources(builder); err != nil {
+			t.Fatalf("Error replacing sources: %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func benchmarkRandomAggr(b *testing.B, parallel bool) {
+	builder := NewRandomAggr().Parallel(parallel)
+	for i := 0; i < 4; i++ {
+		// Equal readers share a lock, so each source needs its own instance.
+		latency := LatencySource(50 * time.Microsecond)
+		builder.Add(&latency, 1)
+	}
+	rnd := builder.Build()
+	buf := make([]byte, DefaultTokenSize)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		rnd.Read(buf)
+	}
+}
+
+func BenchmarkRandomAggrSequential(b *testing.B) {
+	benchmarkRandomAggr(b, false)
+}
+
+func BenchmarkRandomAggrParallel(b *testing.B) {
+	benchmarkRandomAggr(b, true)
+}
+
+func BenchmarkRandomAggrConcurrent(b *testing.B) {
+	rnd := NewRandomAggr().
+		AddSys(8).
+		Add(&CountingSource{}, 1).
+		Build()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		buf := make([]byte, DefaultTokenSize)
+		for pb.Next() {
+			rnd.Read(buf)
+		}
+	})
+}
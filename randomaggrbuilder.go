@@ -20,7 +20,35 @@ package crypt
 
 import (
 	"crypto/rand"
+	"errors"
 	"io"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// Errors returned by RandomAggrBuilder.BuildE. Errors of a specific source are
+// wrapped by a SourceError.
+var (
+	// ErrNoSources is returned when no source was added. It is also returned
+	// by RandomAggr.Read when no source has a positive weight.
+	ErrNoSources = errors.New("no random sources")
+
+	// ErrNilSource is returned when a source has a nil reader.
+	ErrNilSource = errors.New("reader is nil")
+
+	// ErrInvalidWeight is returned when a source has zero or negative weight.
+	ErrInvalidWeight = errors.New("weight must be positive")
+
+	// ErrWeightOverflow is returned when the sum of weights overflows.
+	ErrWeightOverflow = errors.New("sum of weights overflows")
+
+	// ErrDuplicateSource is returned when distinct sources are required and
+	// a reader is added more than once.
+	ErrDuplicateSource = errors.New("duplicate reader")
+
+	// ErrInvalidChunk is returned when interleaving chunk size is negative.
+	ErrInvalidChunk = errors.New("interleaving chunk size is negative")
 )
 
 // A RandomAggrBuilder provides methods to build a new RandomAggr.
@@ -28,15 +56,59 @@ type RandomAggrBuilder interface {
 	// Add a custom random source and specify a weight.
 	Add(io.Reader, int) RandomAggrBuilder
 
-	// AddSSTDEG adds a SSTDEG pseudo-random generator and specifies a weight.
+	// AddDevice adds a device or file which provides random data, like
+	// /dev/urandom, /dev/hwrng or a FIFO, and specifies a weight. Reads fail
+	// while the device is absent or a FIFO has no writer, and once a regular
+	// file is exhausted.
+	AddDevice(string, int) RandomAggrBuilder
+
+	// AddFortuna adds a Fortuna pseudo-random generator, which gathers events
+	// from system pseudo-random generator, CPUJitter and SSTDEG, and
+	// specifies a weight.
+	AddFortuna(int) RandomAggrBuilder
+
+	// AddGetrandom adds Linux getrandom(2) system call as a source, specifies
+	// a weight and its flags, a combination of GrndNonblock and GrndRandom.
+	AddGetrandom(int, int) RandomAggrBuilder
+
+	// AddJitter adds a CPUJitter pseudo-random generator and specifies a
+	// weight.
+	AddJitter(int) RandomAggrBuilder
+
+	// AddNamed adds a custom random source identified by specified name on
+	// errors and metrics, and specifies a weight.
+	AddNamed(string, io.Reader, int) RandomAggrBuilder
+
+	// AddSSTDEG adds a reference to the process-wide SSTDEG pseudo-random
+	// generator and specifies a weight.
 	AddSSTDEG(int) RandomAggrBuilder
 
 	// AddSys adds a system pseudo-random generator and specifies a weight.
 	AddSys(int) RandomAggrBuilder
 
-	// Build creates and returns a new RandomAggr.
+	// Backup defines a source which delivers the shortfall of failing sources
+	// when failure policy is FailOver.
+	Backup(io.Reader) RandomAggrBuilder
+
+	// Build creates and returns a new RandomAggr. Sources created by the
+	// builder, like those added by AddSSTDEG, are created on each build. It
+	// does not validate the configuration, see BuildE. Reading fails with ErrNoSources when no
+	// source has a positive weight.
 	Build() *RandomAggr
 
+	// BuildE validates the configuration, then creates and returns a new
+	// RandomAggr. When validation fails no source is created nor closed, so
+	// the configuration can be fixed and built again. Sources added by Add,
+	// AddNamed and Backup are still owned by the caller.
+	BuildE() (*RandomAggr, error)
+
+	// Distinct defines whether BuildE rejects a reader added more than once.
+	Distinct(bool) RandomAggrBuilder
+
+	// Failure defines how failing sources are handled, defaults to
+	// FailRedistribute.
+	Failure(FailurePolicy) RandomAggrBuilder
+
 	// FastSet get a RandomSource backed 100% by system pseudo-random generator.
 	FastSet() *RandomAggr
 
@@ -44,99 +116,261 @@ type RandomAggrBuilder interface {
 	// generator.
 	InsecureSet() *RandomAggr
 
+	// Interleave defines that sources fill the buffer in round-robin chunks
+	// of specified size, instead of a contiguous portion each.
+	Interleave(int) RandomAggrBuilder
+
+	// Mixing defines how sources are combined, defaults to MixPartition.
+	Mixing(MixMode) RandomAggrBuilder
+
+	// Parallel defines whether sources are read concurrently, each one in its
+	// own routine.
+	Parallel(bool) RandomAggrBuilder
+
 	// SecureSet get a RandomSource backed 84% by system pseudo-random generator
 	// and 16% by SSTDEG pseudo-random generator.
 	SecureSet() *RandomAggr
 }
 
 type rndaggb struct {
-	sources []source
+	sources  []source
+	creators []func() io.Reader
+	mode     MixMode
+	policy   FailurePolicy
+	backup   io.Reader
+	chunk    int
+	parallel bool
+	distinct bool
 }
 
 // NewRandomAggr creates a new instance of RandomAggrBuilder.
 func NewRandomAggr() RandomAggrBuilder {
 	return &rndaggb{
 		make([]source, 0),
+		make([]func() io.Reader, 0),
+		MixPartition,
+		FailRedistribute,
+		nil,
+		0,
+		false,
+		false,
 	}
 }
 
 func (b *rndaggb) Add(r io.Reader, w int) RandomAggrBuilder {
-	b.sources = append(b.sources, source{r, w})
+	b.sources = append(b.sources, source{Reader: r, Weight: w})
+	b.creators = append(b.creators, nil)
+	return b
+}
+
+func (b *rndaggb) AddDevice(path string, w int) RandomAggrBuilder {
+	return b.addCreated(path, func() io.Reader {
+		return NewDevice(path)
+	}, w)
+}
+
+func (b *rndaggb) AddFortuna(w int) RandomAggrBuilder {
+	return b.addCreated("fortuna", func() io.Reader {
+		return NewFortuna(rand.Reader, NewCPUJitter(), AcquireSSTDEG())
+	}, w)
+}
+
+func (b *rndaggb) AddGetrandom(w int, flags int) RandomAggrBuilder {
+	return b.addCreated("getrandom", func() io.Reader {
+		return NewGetrandom(flags)
+	}, w)
+}
+
+func (b *rndaggb) AddJitter(w int) RandomAggrBuilder {
+	return b.addCreated("jitter", func() io.Reader {
+		return NewCPUJitter()
+	}, w)
+}
+
+func (b *rndaggb) AddNamed(name string, r io.Reader, w int) RandomAggrBuilder {
+	b.sources = append(b.sources, source{Name: name, Reader: r, Weight: w})
+	b.creators = append(b.creators, nil)
 	return b
 }
 
 func (b *rndaggb) AddSSTDEG(w int) RandomAggrBuilder {
-	b.sources = append(b.sources, source{NewSSTDEG(), w})
+	return b.addCreated("sstdeg", func() io.Reader {
+		return AcquireSSTDEG()
+	}, w)
+}
+
+// addCreated adds a source whose reader is created by specified function when
+// the builder is built.
+func (b *rndaggb) addCreated(name string, create func() io.Reader, w int) RandomAggrBuilder {
+	b.sources = append(b.sources, source{Name: name, Weight: w})
+	b.creators = append(b.creators, create)
 	return b
 }
 
 func (b *rndaggb) AddSys(w int) RandomAggrBuilder {
-	b.sources = append(b.sources, source{rand.Reader, w})
+	return b.AddNamed("sys", rand.Reader, w)
+}
+
+func (b *rndaggb) Backup(r io.Reader) RandomAggrBuilder {
+	b.backup = r
 	return b
 }
 
 func (b *rndaggb) Build() *RandomAggr {
+	sources := make([]source, len(b.sources))
+	copy(sources, b.sources)
+	for i, create := range b.creators {
+		if create != nil {
+			sources[i].Reader = create()
+		}
+	}
+
+	result := newRandomAggr(sources, b.backup)
+	result.mode = b.mode
+	result.policy = b.policy
+	result.chunk = b.chunk
+	result.parallel = b.parallel
+	return result
+}
+
+func (b *rndaggb) BuildE() (*RandomAggr, error) {
+	if err := b.validate(); err != nil {
+		return nil, err
+	}
+
+	return b.Build(), nil
+}
+
+// validate returns the first error of current configuration.
+func (b *rndaggb) validate() error {
+	if len(b.sources) == 0 {
+		return ErrNoSources
+	}
+	if b.chunk < 0 {
+		return ErrInvalidChunk
+	}
+
 	sum := 0
-	for _, v := range b.sources {
+	seen := make(map[io.Reader]bool)
+	for i, v := range b.sources {
+		// Readers created by the builder are distinct on every build.
+		created := b.creators[i] != nil
+
+		var err error
+		switch {
+		case v.Reader == nil && !created:
+			err = ErrNilSource
+		case v.Weight < 1:
+			err = ErrInvalidWeight
+		case v.Weight > math.MaxInt-sum:
+			err = ErrWeightOverflow
+		case b.distinct && !created &&
+			reflect.TypeOf(v.Reader).Comparable() && seen[v.Reader]:
+			err = ErrDuplicateSource
+		}
+		if err != nil {
+			name := v.Name
+			if name == "" {
+				name = strconv.Itoa(i)
+			}
+			return &SourceError{name, err}
+		}
+
 		sum += v.Weight
+		if !created && reflect.TypeOf(v.Reader).Comparable() {
+			seen[v.Reader] = true
+		}
 	}
 
-	return &RandomAggr{b.sources, sum}
+	return nil
+}
+
+func (b *rndaggb) Distinct(distinct bool) RandomAggrBuilder {
+	b.distinct = distinct
+	return b
+}
+
+func (b *rndaggb) Failure(policy FailurePolicy) RandomAggrBuilder {
+	b.policy = policy
+	return b
 }
 
 func (b *rndaggb) FastSet() *RandomAggr {
-	return &RandomAggr{
+	return newRandomAggr(
 		[]source{
 			{
+				Name:   "sys",
 				Reader: rand.Reader,
 				Weight: 1,
 			},
 		},
-		1,
-	}
+		nil,
+	)
 }
 
 func (b *rndaggb) InsecureSet() *RandomAggr {
-	return &RandomAggr{
+	return newRandomAggr(
 		[]source{
 			{
-				Reader: NewSSTDEG(),
+				Name:   "sstdeg",
+				Reader: AcquireSSTDEG(),
 				Weight: 1,
 			},
 		},
-		1,
-	}
+		nil,
+	)
+}
+
+func (b *rndaggb) Interleave(chunk int) RandomAggrBuilder {
+	b.chunk = chunk
+	return b
+}
+
+func (b *rndaggb) Mixing(mode MixMode) RandomAggrBuilder {
+	b.mode = mode
+	return b
+}
+
+func (b *rndaggb) Parallel(parallel bool) RandomAggrBuilder {
+	b.parallel = parallel
+	return b
 }
 
 func (b *rndaggb) SecureSet() *RandomAggr {
-	sstdeg := NewSSTDEG()
-	return &RandomAggr{
+	sstdeg := AcquireSSTDEG()
+	return newRandomAggr(
 		[]source{
 			{
+				Name:   "sys",
 				Reader: rand.Reader,
 				Weight: 8,
 			},
 			{
+				Name:   "sstdeg",
 				Reader: sstdeg,
 				Weight: 1,
 			},
 			{
+				Name:   "sys",
 				Reader: rand.Reader,
 				Weight: 4,
 			},
 			{
+				Name:   "sstdeg",
 				Reader: sstdeg,
 				Weight: 1,
 			},
 			{
+				Name:   "sys",
 				Reader: rand.Reader,
 				Weight: 4,
 			},
 			{
+				Name:   "sstdeg",
 				Reader: sstdeg,
 				Weight: 1,
 			},
 		},
-		8 + 1 + 4 + 1 + 4 + 1,
-	}
+		nil,
+	)
 }
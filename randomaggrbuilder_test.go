@@ -0,0 +1,163 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestRandomAggrBuildE(t *testing.T) {
+	limited := &LimitedSource{1, 10}
+	tests := []struct {
+		name    string
+		builder RandomAggrBuilder
+		source  string
+		err     error
+	}{
+		{
+			"Empty",
+			NewRandomAggr(),
+			"", ErrNoSources,
+		},
+		{
+			"Nil reader",
+			NewRandomAggr().AddSys(1).Add(nil, 1),
+			"1", ErrNilSource,
+		},
+		{
+			"Zero weight",
+			NewRandomAggr().Add(InfiniteSource(1), 0),
+			"0", ErrInvalidWeight,
+		},
+		{
+			"Negative weight",
+			NewRandomAggr().AddSys(2).Add(InfiniteSource(1), -1),
+			"1", ErrInvalidWeight,
+		},
+		{
+			"Overflow",
+			NewRandomAggr().
+				Add(InfiniteSource(1), math.MaxInt).
+				Add(InfiniteSource(2), 1),
+			"1", ErrWeightOverflow,
+		},
+		{
+			"Overflow of many",
+			NewRandomAggr().
+				Add(InfiniteSource(1), math.MaxInt/2).
+				Add(InfiniteSource(2), math.MaxInt/2).
+				Add(InfiniteSource(3), 2),
+			"2", ErrWeightOverflow,
+		},
+		{
+			"Duplicate",
+			NewRandomAggr().Distinct(true).
+				Add(limited, 1).
+				AddSys(1).
+				Add(limited, 1),
+			"2", ErrDuplicateSource,
+		},
+		{
+			"Negative chunk",
+			NewRandomAggr().AddSys(1).Interleave(-1),
+			"", ErrInvalidChunk,
+		},
+		{
+			"Duplicate allowed",
+			NewRandomAggr().Add(limited, 1).Add(limited, 1),
+			"", nil,
+		},
+		{
+			"Maximum weight",
+			NewRandomAggr().
+				Add(InfiniteSource(1), math.MaxInt-1).
+				Add(InfiniteSource(2), 1),
+			"", nil,
+		},
+	}
+
+	for _, v := range tests {
+		rnd, err := v.builder.BuildE()
+		if !errors.Is(err, v.err) {
+			t.Errorf("%s: expected error %v, got %v", v.name, v.err, err)
+			continue
+		}
+
+		srcErr, ok := err.(*SourceError)
+		switch {
+		case v.err == nil:
+			if rnd == nil {
+				t.Errorf("%s: should build RandomAggr", v.name)
+			}
+		case rnd != nil:
+			t.Errorf("%s: should not build RandomAggr on error", v.name)
+		case v.source == "" && ok:
+			t.Errorf("%s: unexpected source error %v", v.name, err)
+		case v.source != "" && (!ok || srcErr.Source != v.source):
+			t.Errorf("%s: should identify source %s: got %v",
+				v.name, v.source, err)
+		}
+	}
+}
+
+func TestRandomAggrBuildEDistinctValues(t *testing.T) {
+	rnd, err := NewRandomAggr().
+		Distinct(true).
+		Add(InfiniteSource(1), 1).
+		Add(InfiniteSource(2), 1).
+		BuildE()
+	if err != nil {
+		t.Fatalf("Distinct readers should be accepted: %v", err)
+	}
+
+	buf := make([]byte, 10)
+	if n, err := rnd.Read(buf); err != nil || n != len(buf) {
+		t.Errorf("Should fill entire buffer: read %d bytes: %v", n, err)
+	}
+}
+
+func TestRandomAggrBuildERetry(t *testing.T) {
+	src := &closingSource{InfiniteSource: 1}
+	b := NewRandomAggr().AddSSTDEG(1).Add(src, 1).Interleave(-1)
+	_, refs := sharedRefs()
+
+	if _, err := b.BuildE(); err != ErrInvalidChunk {
+		t.Fatalf("Should return ErrInvalidChunk: got %v", err)
+	}
+	if src.closed {
+		t.Error("Sources added by caller should not be closed")
+	}
+	if _, after := sharedRefs(); after != refs {
+		t.Errorf("Sources should not be created on error: %d references",
+			after-refs)
+	}
+
+	rnd, err := b.Interleave(0).BuildE()
+	if err != nil {
+		t.Fatalf("Fixed configuration should be built: %v", err)
+	}
+	defer rnd.Close()
+
+	buf := make([]byte, 10)
+	if n, err := rnd.Read(buf); err != nil || n != len(buf) {
+		t.Errorf("Should fill entire buffer: read %d bytes: %v", n, err)
+	}
+}
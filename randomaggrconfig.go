@@ -0,0 +1,343 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+package crypt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// ErrUnknownKind is returned when a source kind is not registered.
+	ErrUnknownKind = errors.New("unknown source kind")
+
+	// ErrUnknownOption is returned when a configuration option is unknown.
+	ErrUnknownOption = errors.New("unknown option")
+
+	// ErrInvalidOption is returned when a configuration value is invalid.
+	ErrInvalidOption = errors.New("invalid option value")
+)
+
+// Names of configuration values of MixMode and FailurePolicy.
+var (
+	mixModeNames = map[string]MixMode{
+		"":          MixPartition,
+		"partition": MixPartition,
+		"xor":       MixXOR,
+		"hash":      MixHash,
+	}
+	failurePolicyNames = map[string]FailurePolicy{
+		"":             FailRedistribute,
+		"redistribute": FailRedistribute,
+		"closed":       FailClosed,
+		"failover":     FailOver,
+	}
+)
+
+// A ConfigError records an invalid field of a RandomAggrConfig.
+type ConfigError struct {
+	// Field is the path of the offending field, like sources[1].weight.
+	Field string
+	// Err is the validation error.
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+// Unwrap returns the validation error.
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// A SourceConfig defines a source of a RandomAggrConfig.
+type SourceConfig struct {
+	// Kind is a registered source kind, like sys, sstdeg or file.
+	Kind string `json:"kind" yaml:"kind"`
+	// Arg is the argument of source kind, like the path of a file.
+	Arg string `json:"arg,omitempty" yaml:"arg,omitempty"`
+	// Weight is the weight of the source, ignored by backup source.
+	Weight int `json:"weight,omitempty" yaml:"weight,omitempty"`
+}
+
+// name returns the name of the source on errors and metrics.
+func (c SourceConfig) name() string {
+	if c.Arg == "" {
+		return c.Kind
+	}
+	return c.Kind + ":" + c.Arg
+}
+
+// A RandomAggrConfig declares the sources and options of a RandomAggr. It can
+// be parsed from a string spec, JSON or YAML.
+type RandomAggrConfig struct {
+	Sources    []SourceConfig `json:"sources" yaml:"sources"`
+	Mixing     string         `json:"mixing,omitempty" yaml:"mixing,omitempty"`
+	Failure    string         `json:"failure,omitempty" yaml:"failure,omitempty"`
+	Backup     *SourceConfig  `json:"backup,omitempty" yaml:"backup,omitempty"`
+	Interleave int            `json:"interleave,omitempty" yaml:"interleave,omitempty"`
+	Parallel   bool           `json:"parallel,omitempty" yaml:"parallel,omitempty"`
+}
+
+// ParseRandomAggrConfig parses a spec of comma-separated sources, as
+// kind[:arg]:weight, and options, as key=value. For example:
+//
+//	sys:8,sstdeg:1,file:/dev/hwrng:2,mixing=xor
+//
+// Supported options are mixing, failure, backup, interleave and parallel.
+func ParseRandomAggrConfig(spec string) (*RandomAggrConfig, error) {
+	c := &RandomAggrConfig{}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		colon := strings.Index(entry, ":")
+		equal := strings.Index(entry, "=")
+
+		if equal < 0 || (colon >= 0 && colon < equal) {
+			field := "sources[" + strconv.Itoa(len(c.Sources)) + "]"
+			source, err := parseSourceSpec(field, entry)
+			if err != nil {
+				return nil, err
+			}
+			c.Sources = append(c.Sources, source)
+			continue
+		}
+
+		key, value := entry[:equal], entry[equal+1:]
+		var err error
+		switch key {
+		case "mixing":
+			c.Mixing = value
+		case "failure":
+			c.Failure = value
+		case "backup":
+			kind, arg := value, ""
+			if i := strings.Index(value, ":"); i >= 0 {
+				kind, arg = value[:i], value[i+1:]
+			}
+			c.Backup = &SourceConfig{Kind: kind, Arg: arg}
+		case "interleave":
+			c.Interleave, err = strconv.Atoi(value)
+		case "parallel":
+			c.Parallel, err = strconv.ParseBool(value)
+		default:
+			err = ErrUnknownOption
+		}
+		if err == ErrUnknownOption {
+			return nil, &ConfigError{key, err}
+		}
+		if err != nil {
+			return nil, &ConfigError{key, ErrInvalidOption}
+		}
+	}
+
+	return c, c.Validate()
+}
+
+// parseSourceSpec parses a source spec as kind[:arg]:weight.
+func parseSourceSpec(field, spec string) (SourceConfig, error) {
+	first := strings.Index(spec, ":")
+	last := strings.LastIndex(spec, ":")
+	if first < 0 {
+		return SourceConfig{}, &ConfigError{field + ".weight", ErrInvalidWeight}
+	}
+
+	result := SourceConfig{Kind: spec[:first]}
+	if first < last {
+		result.Arg = spec[first+1 : last]
+	}
+
+	weight, err := strconv.Atoi(spec[last+1:])
+	if err != nil {
+		return SourceConfig{}, &ConfigError{field + ".weight", ErrInvalidWeight}
+	}
+	result.Weight = weight
+
+	return result, nil
+}
+
+// ParseRandomAggrJSON parses a JSON document as a RandomAggrConfig.
+func ParseRandomAggrJSON(data []byte) (*RandomAggrConfig, error) {
+	c := &RandomAggrConfig{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return nil, err
+	}
+
+	return c, c.Validate()
+}
+
+// ParseRandomAggrYAML parses a YAML document as a RandomAggrConfig.
+func ParseRandomAggrYAML(data []byte) (*RandomAggrConfig, error) {
+	c := &RandomAggrConfig{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, err
+	}
+
+	return c, c.Validate()
+}
+
+// Validate checks current configuration. Returns a ConfigError pointing at
+// the offending field.
+func (c *RandomAggrConfig) Validate() error {
+	if len(c.Sources) == 0 {
+		return &ConfigError{"sources", ErrNoSources}
+	}
+
+	sum := 0
+	for i, v := range c.Sources {
+		field := "sources[" + strconv.Itoa(i) + "]"
+		if _, ok := lookupSourceKind(v.Kind); !ok {
+			return &ConfigError{field + ".kind", ErrUnknownKind}
+		}
+		if v.Weight < 1 {
+			return &ConfigError{field + ".weight", ErrInvalidWeight}
+		}
+		if v.Weight > math.MaxInt-sum {
+			return &ConfigError{field + ".weight", ErrWeightOverflow}
+		}
+		sum += v.Weight
+	}
+
+	if _, ok := mixModeNames[c.Mixing]; !ok {
+		return &ConfigError{"mixing", ErrInvalidOption}
+	}
+	if _, ok := failurePolicyNames[c.Failure]; !ok {
+		return &ConfigError{"failure", ErrInvalidOption}
+	}
+	if c.Backup != nil {
+		if _, ok := lookupSourceKind(c.Backup.Kind); !ok {
+			return &ConfigError{"backup.kind", ErrUnknownKind}
+		}
+	}
+	if c.Interleave < 0 {
+		return &ConfigError{"interleave", ErrInvalidChunk}
+	}
+
+	return nil
+}
+
+// String returns current configuration as a spec parsed by
+// ParseRandomAggrConfig.
+func (c *RandomAggrConfig) String() string {
+	entries := make([]string, 0, len(c.Sources)+5)
+	for _, v := range c.Sources {
+		entries = append(entries, v.name()+":"+strconv.Itoa(v.Weight))
+	}
+
+	if c.Mixing != "" {
+		entries = append(entries, "mixing="+c.Mixing)
+	}
+	if c.Failure != "" {
+		entries = append(entries, "failure="+c.Failure)
+	}
+	if c.Backup != nil {
+		entries = append(entries, "backup="+c.Backup.name())
+	}
+	if c.Interleave != 0 {
+		entries = append(entries, "interleave="+strconv.Itoa(c.Interleave))
+	}
+	if c.Parallel {
+		entries = append(entries, "parallel=true")
+	}
+
+	return strings.Join(entries, ",")
+}
+
+// Builder validates current configuration and creates a RandomAggrBuilder
+// configured accordingly. Sources are named by their kind and argument, and
+// they are owned by the caller until built.
+func (c *RandomAggrConfig) Builder() (RandomAggrBuilder, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	var created []io.Reader
+	create := func(field string, v SourceConfig) (io.Reader, error) {
+		factory, _ := lookupSourceKind(v.Kind)
+		r, err := factory(v.Arg)
+		if err == nil && r == nil {
+			err = ErrNilSource
+		}
+		if err != nil {
+			for _, item := range created {
+				if closer, ok := item.(io.Closer); ok {
+					closer.Close()
+				}
+			}
+			return nil, &ConfigError{field + ".arg", err}
+		}
+
+		created = append(created, r)
+		return r, nil
+	}
+
+	b := NewRandomAggr().
+		Mixing(mixModeNames[c.Mixing]).
+		Failure(failurePolicyNames[c.Failure]).
+		Interleave(c.Interleave).
+		Parallel(c.Parallel)
+	for i, v := range c.Sources {
+		r, err := create("sources["+strconv.Itoa(i)+"]", v)
+		if err != nil {
+			return nil, err
+		}
+		b.AddNamed(v.name(), r, v.Weight)
+	}
+	if c.Backup != nil {
+		r, err := create("backup", *c.Backup)
+		if err != nil {
+			return nil, err
+		}
+		b.Backup(r)
+	}
+
+	return b, nil
+}
+
+// Build validates current configuration and creates a RandomAggr configured
+// accordingly.
+func (c *RandomAggrConfig) Build() (*RandomAggr, error) {
+	b, err := c.Builder()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := b.BuildE()
+	if err != nil {
+		// Sources created by configuration are owned by current call.
+		b.Build().Close()
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,252 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+package crypt
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// A closingSource records whether it was closed.
+type closingSource struct {
+	InfiniteSource
+	closed bool
+}
+
+func (s *closingSource) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestParseRandomAggrConfig(t *testing.T) {
+	c, err := ParseRandomAggrConfig(
+		"sys:8, sstdeg:1,file:/dev/hwrng:2,mixing=xor,failure=failover," +
+			"backup=file:/dev/urandom,interleave=16,parallel=true")
+	if err != nil {
+		t.Fatalf("Error parsing spec: %v", err)
+	}
+
+	expected := &RandomAggrConfig{
+		Sources: []SourceConfig{
+			{"sys", "", 8},
+			{"sstdeg", "", 1},
+			{"file", "/dev/hwrng", 2},
+		},
+		Mixing:     "xor",
+		Failure:    "failover",
+		Backup:     &SourceConfig{Kind: "file", Arg: "/dev/urandom"},
+		Interleave: 16,
+		Parallel:   true,
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("Unexpected configuration: %+v", c)
+	}
+}
+
+func TestRandomAggrConfigString(t *testing.T) {
+	specs := []string{
+		"sys:8,sstdeg:1,file:/dev/hwrng:2",
+		"getrandom:random|nonblock:1,jitter:3,mixing=hash",
+		"sys:1,failure=failover,backup=file:/dev/urandom,interleave=4,parallel=true",
+	}
+
+	for _, v := range specs {
+		c, err := ParseRandomAggrConfig(v)
+		if err != nil {
+			t.Fatalf("Error parsing %q: %v", v, err)
+		}
+		if s := c.String(); s != v {
+			t.Errorf("Should round-trip %q: got %q", v, s)
+		}
+	}
+}
+
+func TestRandomAggrConfigErrors(t *testing.T) {
+	tests := []struct {
+		spec  string
+		field string
+		err   error
+	}{
+		{"", "sources", ErrNoSources},
+		{"sys", "sources[0].weight", ErrInvalidWeight},
+		{"sys:8,sstdeg:x", "sources[1].weight", ErrInvalidWeight},
+		{"sys:8,sstdeg:0", "sources[1].weight", ErrInvalidWeight},
+		{"sys:8,rdrand:1", "sources[1].kind", ErrUnknownKind},
+		{"sys:" + strconv.Itoa(math.MaxInt) + ",sys:1", "sources[1].weight",
+			ErrWeightOverflow},
+		{"sys:1,mixing=sum", "mixing", ErrInvalidOption},
+		{"sys:1,failure=retry", "failure", ErrInvalidOption},
+		{"sys:1,backup=rdrand", "backup.kind", ErrUnknownKind},
+		{"sys:1,interleave=-1", "interleave", ErrInvalidChunk},
+		{"sys:1,interleave=big", "interleave", ErrInvalidOption},
+		{"sys:1,parallel=maybe", "parallel", ErrInvalidOption},
+		{"sys:1,color=red", "color", ErrUnknownOption},
+	}
+
+	for _, v := range tests {
+		_, err := ParseRandomAggrConfig(v.spec)
+		cfgErr, ok := err.(*ConfigError)
+		if !ok || cfgErr.Field != v.field || !errors.Is(err, v.err) {
+			t.Errorf("%q: expected %s: %v, got %v", v.spec, v.field, v.err, err)
+		}
+	}
+}
+
+func TestRandomAggrConfigBuildErrors(t *testing.T) {
+	tests := []struct {
+		spec  string
+		field string
+		err   error
+	}{
+		{"sys:x:1", "sources[0].arg", ErrUnexpectedArgument},
+		{"sys:1,file:1", "sources[1].arg", ErrMissingArgument},
+		{"getrandom:urandom:1", "sources[0].arg", ErrInvalidOption},
+		{"sys:1,backup=sys:x", "backup.arg", ErrUnexpectedArgument},
+	}
+
+	for _, v := range tests {
+		c, err := ParseRandomAggrConfig(v.spec)
+		if err != nil {
+			t.Fatalf("%q: error parsing spec: %v", v.spec, err)
+		}
+
+		_, err = c.Build()
+		cfgErr, ok := err.(*ConfigError)
+		if !ok || cfgErr.Field != v.field || !errors.Is(err, v.err) {
+			t.Errorf("%q: expected %s: %v, got %v", v.spec, v.field, v.err, err)
+		}
+	}
+}
+
+func TestRandomAggrConfigJSON(t *testing.T) {
+	doc := `{
+		"sources": [
+			{"kind": "sys", "weight": 8},
+			{"kind": "file", "arg": "/dev/hwrng", "weight": 2}
+		],
+		"mixing": "xor"
+	}`
+
+	c, err := ParseRandomAggrJSON([]byte(doc))
+	if err != nil {
+		t.Fatalf("Error parsing JSON: %v", err)
+	}
+	if s := c.String(); s != "sys:8,file:/dev/hwrng:2,mixing=xor" {
+		t.Errorf("Unexpected configuration: %s", s)
+	}
+
+	data, _ := json.Marshal(c)
+	decoded, err := ParseRandomAggrJSON(data)
+	if err != nil || !reflect.DeepEqual(c, decoded) {
+		t.Errorf("Should round-trip JSON %s: %v", data, err)
+	}
+
+	_, err = ParseRandomAggrJSON([]byte(`{"sources": [{"kind": "sys"}]}`))
+	if err, ok := err.(*ConfigError); !ok || err.Field != "sources[0].weight" {
+		t.Errorf("Should point at missing weight: got %v", err)
+	}
+	if _, err := ParseRandomAggrJSON([]byte(`{"source": []}`)); err == nil {
+		t.Error("Should reject unknown fields")
+	}
+}
+
+func TestRandomAggrConfigYAML(t *testing.T) {
+	doc := `
+sources:
+  - kind: sys
+    weight: 8
+  - kind: sstdeg
+    weight: 1
+failure: closed
+interleave: 8
+`
+
+	c, err := ParseRandomAggrYAML([]byte(doc))
+	if err != nil {
+		t.Fatalf("Error parsing YAML: %v", err)
+	}
+	if s := c.String(); s != "sys:8,sstdeg:1,failure=closed,interleave=8" {
+		t.Errorf("Unexpected configuration: %s", s)
+	}
+
+	_, err = ParseRandomAggrYAML([]byte("sources:\n  - kind: tpm\n    weight: 1\n"))
+	if err, ok := err.(*ConfigError); !ok || err.Field != "sources[0].kind" {
+		t.Errorf("Should point at unknown kind: got %v", err)
+	}
+	if _, err := ParseRandomAggrYAML([]byte("sources: []\nmix: xor\n")); err == nil {
+		t.Error("Should reject unknown fields")
+	}
+}
+
+func TestRandomAggrConfigBuild(t *testing.T) {
+	path, cleanup := tempDevice(t)
+	defer cleanup()
+	ioutil.WriteFile(path, make([]byte, 30), 0600)
+
+	RegisterSourceKind("constant", func(arg string) (io.Reader, error) {
+		v, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, ErrInvalidOption
+		}
+		return InfiniteSource(v), nil
+	})
+
+	c, err := ParseRandomAggrConfig(
+		"constant:7:1,file:" + path + ":1,failure=closed")
+	if err != nil {
+		t.Fatalf("Error parsing spec: %v", err)
+	}
+	rnd, err := c.Build()
+	if err != nil {
+		t.Fatalf("Error building RandomAggr: %v", err)
+	}
+	defer rnd.Close()
+
+	buf := make([]byte, 60)
+	if n, err := rnd.Read(buf); err != nil || n != len(buf) {
+		t.Fatalf("Should fill entire buffer: read %d bytes: %v", n, err)
+	}
+	testValues(buf[:30], 7, t)
+	testValues(buf[30:], 0, t)
+
+	_, err = rnd.Read(buf)
+	if err, ok := err.(*SourceError); !ok || err.Source != "file:"+path {
+		t.Errorf("Sources should be named by kind and argument: got %v", err)
+	}
+}
+
+func TestRandomAggrConfigBuildCloses(t *testing.T) {
+	created := &closingSource{}
+	RegisterSourceKind("closing", func(arg string) (io.Reader, error) {
+		return created, nil
+	})
+
+	c, _ := ParseRandomAggrConfig("closing:1,file:1")
+	if _, err := c.Build(); err == nil {
+		t.Fatal("Should fail without file path")
+	}
+	if !created.closed {
+		t.Error("Should close sources created before failure")
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+package crypt
+
+import (
+	"encoding/binary"
+	"io"
+	mrand "math/rand"
+	randv2 "math/rand/v2"
+	"sync"
+)
+
+// randSourceBufferSize defines how many bytes RandSource reads at once.
+const randSourceBufferSize = 512
+
+// A RandSource adapts a reader of random data, like a RandomAggr or a
+// ChaCha20, as a source of math/rand and math/rand/v2 packages. Data is read
+// in blocks and served bytes are erased from its buffer. It is safe for
+// concurrent use.
+//
+// Since sources cannot return errors, RandSource panics when its reader
+// fails.
+type RandSource struct {
+	rnd   io.Reader
+	buf   [randSourceBufferSize]byte
+	pos   int
+	mutex *sync.Mutex
+}
+
+// NewRandSource creates a new instance of RandSource which reads from
+// specified reader. It can be used as rand.New(crypt.NewRandSource(aggr)).
+func NewRandSource(rnd io.Reader) *RandSource {
+	return &RandSource{
+		rnd:   rnd,
+		pos:   randSourceBufferSize,
+		mutex: &sync.Mutex{},
+	}
+}
+
+// Int63 returns a non-negative random 63-bit integer.
+func (s *RandSource) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+// Seed does nothing, since random data cannot be seeded.
+func (s *RandSource) Seed(seed int64) {}
+
+// Uint64 returns a random 64-bit integer.
+func (s *RandSource) Uint64() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.pos == len(s.buf) {
+		if _, err := io.ReadFull(s.rnd, s.buf[:]); err != nil {
+			panic("crypt: RandSource cannot read random data: " + err.Error())
+		}
+		s.pos = 0
+	}
+
+	block := s.buf[s.pos : s.pos+8]
+	result := binary.LittleEndian.Uint64(block)
+	zero(block)
+	s.pos += 8
+
+	return result
+}
+
+var _ mrand.Source64 = (*RandSource)(nil)
+var _ randv2.Source = (*RandSource)(nil)
@@ -0,0 +1,145 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+package crypt
+
+import (
+	"bytes"
+	mrand "math/rand"
+	randv2 "math/rand/v2"
+	"sync"
+	"testing"
+)
+
+// A countingReader counts calls to Read of a sequence of bytes.
+type countingReader struct {
+	next  byte
+	calls int
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	r.calls++
+	for i := range b {
+		b[i] = r.next
+		r.next++
+	}
+
+	return len(b), nil
+}
+
+func TestRandSourceUint64(t *testing.T) {
+	reader := &countingReader{}
+	src := NewRandSource(reader)
+
+	if v := src.Uint64(); v != 0x0706050403020100 {
+		t.Errorf("Should decode little-endian integers: got %#x", v)
+	}
+	if v := src.Int63(); v != 0x0f0e0d0c0b0a0908>>1 {
+		t.Errorf("Should return non-negative integer: got %#x", v)
+	}
+
+	for i := 2; i < randSourceBufferSize/8; i++ {
+		src.Uint64()
+	}
+	if reader.calls != 1 {
+		t.Errorf("Should read in blocks: got %d reads", reader.calls)
+	}
+	if !bytes.Equal(src.buf[:], make([]byte, randSourceBufferSize)) {
+		t.Error("Served bytes should be erased from buffer")
+	}
+
+	src.Uint64()
+	if reader.calls != 2 {
+		t.Errorf("Should refill exhausted buffer: got %d reads", reader.calls)
+	}
+}
+
+func TestRandSourceMathRand(t *testing.T) {
+	aggr := NewRandomAggr().FastSet()
+	defer aggr.Close()
+	rnd := mrand.New(NewRandSource(aggr))
+
+	counts := make([]int, 10)
+	for i := 0; i < 10000; i++ {
+		counts[rnd.Intn(len(counts))]++
+	}
+	for i, v := range counts {
+		if v < 800 || v > 1200 {
+			t.Errorf("Value %d is biased: %d of 10000", i, v)
+		}
+	}
+
+	perm := rnd.Perm(100)
+	seen := make(map[int]bool)
+	for _, v := range perm {
+		seen[v] = true
+	}
+	if len(seen) != 100 {
+		t.Error("Should generate a valid permutation")
+	}
+}
+
+func TestRandSourceMathRandV2(t *testing.T) {
+	rnd := randv2.New(NewRandSource(NewRandomAggr().FastSet()))
+
+	counts := make([]int, 10)
+	for i := 0; i < 10000; i++ {
+		counts[rnd.IntN(len(counts))]++
+	}
+	for i, v := range counts {
+		if v < 800 || v > 1200 {
+			t.Errorf("Value %d is biased: %d of 10000", i, v)
+		}
+	}
+}
+
+func TestRandSourceConcurrent(t *testing.T) {
+	src := NewRandSource(NewChaCha20(NewRandomAggr().FastSet()))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				src.Uint64()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRandSourceFailure(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Should panic when reader fails")
+		}
+	}()
+
+	NewRandSource(&LimitedSource{1, 10}).Uint64()
+}
+
+func BenchmarkRandSource(b *testing.B) {
+	cipher := NewChaCha20(NewRandomAggr().SecureSet())
+	defer cipher.Close()
+	rnd := mrand.New(NewRandSource(cipher))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		rnd.Int63()
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+package crypt
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// A Recorder tees random data read from a source to a writer, like a file, so
+// it can be played back by a Replay to reproduce a failure exactly. Reads are
+// serialized to record data in the order it is served.
+type Recorder struct {
+	rnd    io.Reader
+	w      io.Writer
+	closed bool
+	mutex  *sync.Mutex
+}
+
+// NewRecorder creates a new instance of Recorder which reads from rnd and
+// writes every byte read to w.
+func NewRecorder(rnd io.Reader, w io.Writer) *Recorder {
+	return &Recorder{
+		rnd:   rnd,
+		w:     w,
+		mutex: &sync.Mutex{},
+	}
+}
+
+// NewRecorderFile creates a new instance of Recorder which reads from rnd and
+// records to specified file, which is created or truncated.
+func NewRecorderFile(rnd io.Reader, path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRecorder(rnd, file), nil
+}
+
+// Close closes the source and the writer when they implement io.Closer.
+func (s *Recorder) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	var err error
+	if closer, ok := s.rnd.(io.Closer); ok {
+		err = closer.Close()
+	}
+	if closer, ok := s.w.(io.Closer); ok {
+		if itemErr := closer.Close(); err == nil {
+			err = itemErr
+		}
+	}
+
+	return err
+}
+
+// Read fills specified byte array with data read from the source and records
+// it.
+//
+// Returns ErrClosed when current instance is closed and the error of the
+// writer when data cannot be recorded.
+func (s *Recorder) Read(b []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	n, err := s.rnd.Read(b)
+	if n > 0 {
+		if _, wErr := s.w.Write(b[:n]); wErr != nil {
+			return n, wErr
+		}
+	}
+
+	return n, err
+}
+
+var _ io.ReadCloser = (*Recorder)(nil)
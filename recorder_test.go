@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// A failingWriter fails every write.
+type failingWriter struct {
+	err error
+}
+
+func (w failingWriter) Write(b []byte) (int, error) {
+	return 0, w.err
+}
+
+func TestRecorderRead(t *testing.T) {
+	var record bytes.Buffer
+	rnd := NewRecorder(&CountingSource{}, &record)
+
+	served := make([]byte, 0, 100)
+	for i := 1; i < 10; i++ {
+		buf := make([]byte, i)
+		if n, err := rnd.Read(buf); err != nil || n != i {
+			t.Fatalf("Error reading: got %d and %v", n, err)
+		}
+		served = append(served, buf...)
+	}
+
+	if !bytes.Equal(served, record.Bytes()) {
+		t.Error("Should record every byte served")
+	}
+}
+
+func TestRecorderSourceError(t *testing.T) {
+	errFake := errors.New("fake error")
+	var record bytes.Buffer
+	rnd := NewRecorder(&FailingSource{1, 5, errFake}, &record)
+
+	buf := make([]byte, 10)
+	n, err := rnd.Read(buf)
+	if n != 5 || err != nil {
+		t.Errorf("Should read available data: got %d and %v", n, err)
+	}
+	if n, err = rnd.Read(buf); n != 0 || err != errFake {
+		t.Errorf("Should return source error: got %d and %v", n, err)
+	}
+	if record.Len() != 5 {
+		t.Errorf("Should record data read before error: got %d bytes",
+			record.Len())
+	}
+}
+
+func TestRecorderWriteError(t *testing.T) {
+	errFake := errors.New("fake error")
+	rnd := NewRecorder(InfiniteSource(1), failingWriter{errFake})
+
+	if _, err := rnd.Read(make([]byte, 10)); err != errFake {
+		t.Errorf("Should return writer error: got %v", err)
+	}
+}
+
+func TestRecorderFile(t *testing.T) {
+	path, cleanup := tempDevice(t)
+	defer cleanup()
+
+	source := &closingSource{InfiniteSource: 7}
+	rnd, err := NewRecorderFile(source, path)
+	if err != nil {
+		t.Fatalf("Error creating recorder: %v", err)
+	}
+	rnd.Read(make([]byte, 10))
+
+	if err := rnd.Close(); err != nil {
+		t.Errorf("Error closing recorder: %v", err)
+	}
+	if !source.closed {
+		t.Error("Should close the source")
+	}
+	if _, err := rnd.Read(make([]byte, 1)); err != ErrClosed {
+		t.Errorf("Should return ErrClosed: got %v", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil || !bytes.Equal(data, bytes.Repeat([]byte{7}, 10)) {
+		t.Errorf("Should record to file: got %v and %v", data, err)
+	}
+
+	_, err = NewRecorderFile(source, filepath.Join(path, "missing", "file"))
+	if err == nil {
+		t.Error("Should fail to create file")
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+package crypt
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// ErrReplayExhausted is returned when a Replay has served all recorded data.
+var ErrReplayExhausted = errors.New("recorded random data is exhausted")
+
+// A Replay plays back random data recorded by a Recorder, deterministically
+// replacing a source like a RandomAggr or a SSTDEG. Unlike a Device, it fails
+// once recorded data is exhausted instead of reading it again.
+type Replay struct {
+	data   []byte
+	pos    int
+	closed bool
+	mutex  *sync.Mutex
+}
+
+// NewReplay creates a new instance of Replay which plays back specified data.
+func NewReplay(data []byte) *Replay {
+	return &Replay{
+		data:  data,
+		mutex: &sync.Mutex{},
+	}
+}
+
+// OpenReplay creates a new instance of Replay which plays back data recorded
+// on specified file.
+func OpenReplay(path string) (*Replay, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReplay(data), nil
+}
+
+// Close releases recorded data.
+func (s *Replay) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	s.data = nil
+	s.pos = 0
+	return nil
+}
+
+// Read fills specified byte array with next recorded data.
+//
+// Returns ErrClosed when current instance is closed and ErrReplayExhausted
+// when there is not enough recorded data left.
+func (s *Replay) Read(b []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	n := copy(b, s.data[s.pos:])
+	s.pos += n
+	if n < len(b) {
+		return n, ErrReplayExhausted
+	}
+
+	return n, nil
+}
+
+// Remaining returns how many bytes of recorded data are left.
+func (s *Replay) Remaining() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.data) - s.pos
+}
+
+var _ io.ReadCloser = (*Replay)(nil)
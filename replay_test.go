@@ -0,0 +1,151 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplayRead(t *testing.T) {
+	rnd := NewReplay([]byte("0123456789"))
+
+	buf := make([]byte, 4)
+	if n, err := rnd.Read(buf); err != nil || string(buf[:n]) != "0123" {
+		t.Errorf("Should play back recorded data: got %q and %v",
+			buf[:n], err)
+	}
+	if n := rnd.Remaining(); n != 6 {
+		t.Errorf("Unexpected remaining data: got %d", n)
+	}
+
+	buf = make([]byte, 10)
+	n, err := rnd.Read(buf)
+	if string(buf[:n]) != "456789" || err != ErrReplayExhausted {
+		t.Errorf("Should fail on exhaustion: got %q and %v", buf[:n], err)
+	}
+	if n, err := rnd.Read(buf); n != 0 || err != ErrReplayExhausted {
+		t.Errorf("Should fail on exhaustion: got %d and %v", n, err)
+	}
+
+	rnd.Close()
+	if _, err := rnd.Read(buf); err != ErrClosed {
+		t.Errorf("Should return ErrClosed: got %v", err)
+	}
+}
+
+func TestReplayOpen(t *testing.T) {
+	path, cleanup := tempDevice(t)
+	defer cleanup()
+
+	if _, err := OpenReplay(path); !os.IsNotExist(err) {
+		t.Errorf("Should fail to open missing file: got %v", err)
+	}
+
+	c, err := ParseRandomAggrConfig("replay:" + path + ":1")
+	if err != nil {
+		t.Fatalf("Error parsing config: %v", err)
+	}
+	if _, err := c.Build(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Should fail to open missing file: got %v", err)
+	}
+
+	c, _ = ParseRandomAggrConfig("replay:1")
+	if _, err := c.Build(); !errors.Is(err, ErrMissingArgument) {
+		t.Errorf("Should require a path: got %v", err)
+	}
+}
+
+func TestReplaySalter(t *testing.T) {
+	path, cleanup := tempDevice(t)
+	defer cleanup()
+
+	record, err := NewRecorderFile(NewRandomAggr().InsecureSet(), path)
+	if err != nil {
+		t.Fatalf("Error creating recorder: %v", err)
+	}
+	salter := NewSalter(record, []byte("input"))
+	tokens := make([]string, 10)
+	for i := range tokens {
+		if tokens[i], err = salter.Token(0); err != nil {
+			t.Fatalf("Error creating token: %v", err)
+		}
+	}
+	record.Close()
+
+	replay, err := OpenReplay(path)
+	if err != nil {
+		t.Fatalf("Error opening replay: %v", err)
+	}
+	salter = NewSalter(replay, []byte("input"))
+	for i, v := range tokens {
+		if token, _ := salter.Token(0); token != v {
+			t.Errorf("Token %d should be reproduced: got %q instead of %q",
+				i, token, v)
+		}
+	}
+	if _, err := salter.Token(0); err == nil {
+		t.Error("Should fail when recorded data is exhausted")
+	}
+}
+
+func TestReplayRandomAggr(t *testing.T) {
+	path, cleanup := tempDevice(t)
+	defer cleanup()
+	dir := filepath.Dir(path)
+
+	// Each source is recorded on its own, so their data is played back to
+	// the same portions
+	names := []string{"sys", "jitter"}
+	paths := []string{filepath.Join(dir, "sys"), filepath.Join(dir, "jitter")}
+	builder := NewRandomAggr().Interleave(7)
+	for i, v := range []io.Reader{NewRandomAggr().InsecureSet(), NewCPUJitter()} {
+		record, err := NewRecorderFile(v, paths[i])
+		if err != nil {
+			t.Fatalf("Error creating recorder: %v", err)
+		}
+		builder.AddNamed(names[i], record, i+1)
+	}
+	rnd := builder.Build()
+	expected := make([]byte, 100)
+	rnd.Read(expected)
+	rnd.Close()
+
+	builder = NewRandomAggr().Interleave(7)
+	for i, v := range paths {
+		replay, err := OpenReplay(v)
+		if err != nil {
+			t.Fatalf("Error opening replay: %v", err)
+		}
+		builder.AddNamed(names[i], replay, i+1)
+	}
+	rnd = builder.Build()
+	defer rnd.Close()
+
+	buf := make([]byte, 100)
+	if n, err := rnd.Read(buf); err != nil || !bytes.Equal(buf[:n], expected) {
+		t.Errorf("Should reproduce aggregated data: got %v", err)
+	}
+	if _, err := rnd.Read(buf); err == nil {
+		t.Error("Should fail when recorded data is exhausted")
+	}
+}
@@ -22,7 +22,7 @@
 package main
 
 import (
-	"bufio"
+	"io"
 	"os"
 
 	"github.com/raiqub/crypt"
@@ -32,10 +32,5 @@ func main() {
 	rng := crypt.NewSSTDEG()
 	defer rng.Close()
 
-	scanner := bufio.NewScanner(rng)
-	sout := bufio.NewWriter(os.Stdout)
-
-	for scanner.Scan() {
-		sout.Write(scanner.Bytes())
-	}
+	io.Copy(os.Stdout, rng)
 }
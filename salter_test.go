@@ -72,3 +72,15 @@ func BenchmarkSalterSecure(b *testing.B) {
 	b.StopTimer()
 	salter.Dispose()
 }
+
+func BenchmarkSalterChaCha20(b *testing.B) {
+	salter := NewSalter(NewChaCha20(NewRandomAggr().SecureSet()), nil)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		salter.Token(0)
+	}
+
+	b.StopTimer()
+	salter.Dispose()
+}
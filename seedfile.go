@@ -0,0 +1,181 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// seedFileSize defines the size of random data stored on seed file,
+	// which is followed by its checksum.
+	seedFileSize = 512
+
+	// seedFileMode defines the permissions of seed file.
+	seedFileMode = 0600
+)
+
+var (
+	// ErrSeedInvalid is returned when seed file is truncated or corrupted.
+	ErrSeedInvalid = errors.New("seed file is truncated or corrupted")
+
+	// ErrSeedInsufficient is returned when entropy pool has not enough data
+	// to save a seed file.
+	ErrSeedInsufficient = errors.New("not enough entropy to save seed file")
+)
+
+// LoadSeedFile mixes the seed file stored at path into entropy pool and
+// immediately overwrites it, so the same seed is never used twice. The next
+// seed is derived from the seed file and from data gathered by the pool so
+// far, which is taken out of the pool. The pool is only credited with the size
+// of the seed when the seed file is valid and was overwritten. A missing seed
+// file is ignored.
+//
+// Returns ErrSeedInvalid when seed file is truncated or corrupted.
+func (s *SSTDEG) LoadSeedFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrClosed
+	}
+	fresh := make([]byte, seedFileSize)
+	fresh = fresh[:s.pool.take(fresh)]
+	s.metrics.Set(MetricSSTDEGPoolSize, "", int64(s.pool.size))
+	s.mutex.Unlock()
+
+	next := expandSeed(seedFileSize, append(fresh, data...), []byte("next"))
+	zero(fresh)
+	err = writeSeedFile(path, next)
+	zero(next)
+	if err == nil && !validSeed(data) {
+		err = ErrSeedInvalid
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	s.pool.mix(expandSeed(defaultPoolSize, data, []byte("pool")))
+	if err == nil {
+		s.pool.credit(seedFileSize)
+		s.metrics.Set(MetricSSTDEGPoolSize, "", int64(s.pool.size))
+	}
+
+	return err
+}
+
+// SaveSeedFile takes random data out of entropy pool, so it is never served
+// to readers, and writes a hash of it to path, which should be loaded by
+// LoadSeedFile on next startup. Raw pool data is never written to disk. The
+// seed file is only readable by its owner.
+//
+// Returns ErrSeedInsufficient when entropy pool has not enough data.
+func (s *SSTDEG) SaveSeedFile(path string) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrClosed
+	}
+	if s.pool.size < seedFileSize {
+		s.mutex.Unlock()
+		return ErrSeedInsufficient
+	}
+	data := make([]byte, seedFileSize)
+	s.pool.take(data)
+	s.metrics.Set(MetricSSTDEGPoolSize, "", int64(s.pool.size))
+	s.mutex.Unlock()
+
+	snapshot := expandSeed(seedFileSize, data, []byte("save"))
+	zero(data)
+	err := writeSeedFile(path, snapshot)
+	zero(snapshot)
+	return err
+}
+
+// expandSeed derives size bytes from input and a context string by hashing
+// them with a counter.
+func expandSeed(size int, input, context []byte) []byte {
+	result := make([]byte, 0, size+sha256.Size)
+	var counter [4]byte
+
+	for i := uint32(0); len(result) < size; i++ {
+		binary.LittleEndian.PutUint32(counter[:], i)
+
+		hash := sha256.New()
+		hash.Write(counter[:])
+		hash.Write(context)
+		hash.Write(input)
+		result = hash.Sum(result)
+	}
+
+	return result[:size]
+}
+
+// validSeed determines whether seed file contents has expected size and
+// checksum.
+func validSeed(data []byte) bool {
+	if len(data) != seedFileSize+sha256.Size {
+		return false
+	}
+
+	sum := sha256.Sum256(data[:seedFileSize])
+	return bytes.Equal(sum[:], data[seedFileSize:])
+}
+
+// writeSeedFile atomically replaces seed file by specified data followed by
+// its checksum.
+func writeSeedFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	sum := sha256.Sum256(data)
+	if err = tmp.Chmod(seedFileMode); err == nil {
+		if _, err = tmp.Write(append(data, sum[:]...)); err == nil {
+			err = tmp.Sync()
+		}
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
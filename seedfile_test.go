@@ -0,0 +1,227 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// newIdleSSTDEG creates a SSTDEG instance which does not gather entropy.
+func newIdleSSTDEG() *SSTDEG {
+	return NewSSTDEGWithClock(newFakeClock())
+}
+
+func tempSeedFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "crypt")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %v", err)
+	}
+
+	return filepath.Join(dir, "random-seed"), func() {
+		os.Chmod(dir, 0700)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSeedFileWarmStart(t *testing.T) {
+	path, cleanup := tempSeedFile(t)
+	defer cleanup()
+
+	source := newFedSSTDEG()
+	for source.EntropyAvailable() < defaultPoolSize {
+		runtime.Gosched()
+	}
+	if err := source.SaveSeedFile(path); err != nil {
+		t.Fatalf("Error saving seed file: %v", err)
+	}
+	source.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Error reading seed file: %v", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != seedFileMode {
+		t.Errorf("Unexpected seed file permissions: %v", info.Mode())
+	}
+	saved, _ := ioutil.ReadFile(path)
+
+	rnd := newIdleSSTDEG()
+	defer rnd.Close()
+
+	if err := rnd.LoadSeedFile(path); err != nil {
+		t.Fatalf("Error loading seed file: %v", err)
+	}
+	if n := rnd.EntropyAvailable(); n != seedFileSize {
+		t.Errorf("Seed should credit its own size only: got %d", n)
+	}
+
+	loaded, _ := ioutil.ReadFile(path)
+	if bytes.Equal(saved, loaded) {
+		t.Error("Seed file should be overwritten after loading")
+	}
+	if !validSeed(loaded) {
+		t.Error("Overwritten seed file should be valid")
+	}
+}
+
+func TestSeedFileReuse(t *testing.T) {
+	path, cleanup := tempSeedFile(t)
+	defer cleanup()
+
+	if err := writeSeedFile(path, sequence(seedFileSize)); err != nil {
+		t.Fatalf("Error writing seed file: %v", err)
+	}
+
+	first := newIdleSSTDEG()
+	defer first.Close()
+	second := newIdleSSTDEG()
+	defer second.Close()
+
+	first.LoadSeedFile(path)
+	second.LoadSeedFile(path)
+
+	buf1 := make([]byte, seedFileSize)
+	buf2 := make([]byte, seedFileSize)
+	first.Read(buf1)
+	second.Read(buf2)
+	if bytes.Equal(buf1, buf2) {
+		t.Error("Loading seed file twice should not reuse the same seed")
+	}
+}
+
+func TestSeedFileTakesPool(t *testing.T) {
+	path, cleanup := tempSeedFile(t)
+	defer cleanup()
+
+	rnd := newIdleSSTDEG()
+	defer rnd.Close()
+
+	if err := rnd.SaveSeedFile(path); err != ErrSeedInsufficient {
+		t.Errorf("Should return ErrSeedInsufficient: got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Seed file should not be written without entropy")
+	}
+
+	rnd.mutex.Lock()
+	for _, v := range sequence(seedFileSize + 10) {
+		rnd.pool.add(v)
+	}
+	rnd.mutex.Unlock()
+
+	if err := rnd.SaveSeedFile(path); err != nil {
+		t.Fatalf("Error saving seed file: %v", err)
+	}
+	if n := rnd.EntropyAvailable(); n != 10 {
+		t.Errorf("Seed should be taken out of entropy pool: got %d", n)
+	}
+
+	saved, _ := ioutil.ReadFile(path)
+	expected := expandSeed(seedFileSize, sequence(seedFileSize), []byte("save"))
+	if !bytes.Equal(saved[:seedFileSize], expected) {
+		t.Error("Seed file should hold a hash of data taken out of entropy pool")
+	}
+	if bytes.Contains(saved, sequence(16)) {
+		t.Error("Seed file should not hold raw pool data")
+	}
+
+	if err := rnd.LoadSeedFile(path); err != nil {
+		t.Fatalf("Error loading seed file: %v", err)
+	}
+	if n := rnd.EntropyAvailable(); n != seedFileSize {
+		t.Errorf("Fresh data should be taken out of entropy pool: got %d", n)
+	}
+
+	unfed := newIdleSSTDEG()
+	defer unfed.Close()
+	writeSeedFile(path, saved[:seedFileSize])
+	unfed.LoadSeedFile(path)
+	next, _ := ioutil.ReadFile(path)
+	writeSeedFile(path, saved[:seedFileSize])
+	rnd.LoadSeedFile(path)
+	fresh, _ := ioutil.ReadFile(path)
+	if bytes.Equal(next, fresh) {
+		t.Error("Next seed should mix fresh data from entropy pool")
+	}
+}
+
+func TestSeedFileMissing(t *testing.T) {
+	path, cleanup := tempSeedFile(t)
+	defer cleanup()
+
+	rnd := newIdleSSTDEG()
+	defer rnd.Close()
+
+	if err := rnd.LoadSeedFile(path); err != nil {
+		t.Errorf("Missing seed file should be ignored: %v", err)
+	}
+	if n := rnd.EntropyAvailable(); n != 0 {
+		t.Errorf("Missing seed file should not credit entropy: got %d", n)
+	}
+}
+
+func TestSeedFileTruncated(t *testing.T) {
+	path, cleanup := tempSeedFile(t)
+	defer cleanup()
+
+	rnd := newIdleSSTDEG()
+	defer rnd.Close()
+	writeSeedFile(path, sequence(seedFileSize))
+
+	data, _ := ioutil.ReadFile(path)
+	ioutil.WriteFile(path, data[:len(data)/2], seedFileMode)
+
+	if err := rnd.LoadSeedFile(path); err != ErrSeedInvalid {
+		t.Errorf("Should return ErrSeedInvalid: got %v", err)
+	}
+	if n := rnd.EntropyAvailable(); n != 0 {
+		t.Errorf("Truncated seed file should not credit entropy: got %d", n)
+	}
+
+	data, _ = ioutil.ReadFile(path)
+	if !validSeed(data) {
+		t.Error("Truncated seed file should be replaced")
+	}
+}
+
+func TestSeedFileReadOnly(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Getuid() == 0 {
+		t.Skip("Directory permissions are not enforced")
+	}
+
+	path, cleanup := tempSeedFile(t)
+	defer cleanup()
+
+	rnd := newIdleSSTDEG()
+	defer rnd.Close()
+	writeSeedFile(path, sequence(seedFileSize))
+	os.Chmod(filepath.Dir(path), 0500)
+
+	if err := rnd.LoadSeedFile(path); err == nil {
+		t.Error("Should fail when seed file cannot be overwritten")
+	}
+	if n := rnd.EntropyAvailable(); n != 0 {
+		t.Errorf("Reusable seed file should not credit entropy: got %d", n)
+	}
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// shared holds the process-wide SSTDEG instance and its reference count.
+var shared struct {
+	mutex    sync.Mutex
+	instance *SSTDEG
+	refs     int
+	metrics  Metrics
+}
+
+// A SharedSSTDEG represents a reference to the process-wide SSTDEG instance.
+type SharedSSTDEG struct {
+	instance *SSTDEG
+	closed   int32
+}
+
+// AcquireSSTDEG returns a new reference to the process-wide SSTDEG instance,
+// creating it when needed. The instance is closed when the last reference is
+// released.
+func AcquireSSTDEG() *SharedSSTDEG {
+	shared.mutex.Lock()
+	defer shared.mutex.Unlock()
+
+	if shared.instance == nil {
+		shared.instance = NewSSTDEGWithOptions(SSTDEGOptions{
+			Metrics: shared.metrics,
+		})
+	}
+	shared.refs++
+
+	return &SharedSSTDEG{instance: shared.instance}
+}
+
+// SetSharedSSTDEGMetrics sets the receiver of operational metrics of the
+// process-wide SSTDEG instance. It applies to the running instance and to any
+// instance created afterwards.
+func SetSharedSSTDEGMetrics(m Metrics) {
+	shared.mutex.Lock()
+	defer shared.mutex.Unlock()
+
+	shared.metrics = m
+	if shared.instance != nil {
+		shared.instance.SetMetrics(m)
+	}
+}
+
+// Close releases current reference. It is safe to call Close more than once.
+func (s *SharedSSTDEG) Close() error {
+	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		return nil
+	}
+
+	shared.mutex.Lock()
+	defer shared.mutex.Unlock()
+
+	shared.refs--
+	if shared.refs > 0 {
+		return nil
+	}
+
+	err := shared.instance.Close()
+	shared.instance = nil
+	return err
+}
+
+// EntropyAvailable returns the entropy pool size of shared instance.
+// Returns zero when current reference is released.
+func (s *SharedSSTDEG) EntropyAvailable() int {
+	if s.released() {
+		return 0
+	}
+
+	return s.instance.EntropyAvailable()
+}
+
+// Read fills specified byte array with random data from shared instance.
+//
+// Returns ErrClosed when current reference is released.
+func (s *SharedSSTDEG) Read(b []byte) (int, error) {
+	if s.released() {
+		return 0, ErrClosed
+	}
+
+	return s.instance.Read(b)
+}
+
+// WriteTo writes a continuous stream of random data to w from shared
+// instance.
+//
+// Returns ErrClosed when current reference is released.
+func (s *SharedSSTDEG) WriteTo(w io.Writer) (int64, error) {
+	if s.released() {
+		return 0, ErrClosed
+	}
+
+	return s.instance.WriteTo(w)
+}
+
+// released determines whether current reference was released.
+func (s *SharedSSTDEG) released() bool {
+	return atomic.LoadInt32(&s.closed) != 0
+}
+
+var _ io.ReadCloser = (*SharedSSTDEG)(nil)
+var _ io.WriterTo = (*SharedSSTDEG)(nil)
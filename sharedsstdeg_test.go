@@ -0,0 +1,195 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+
+package crypt
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func sharedRefs() (*SSTDEG, int) {
+	shared.mutex.Lock()
+	defer shared.mutex.Unlock()
+
+	return shared.instance, shared.refs
+}
+
+// waitGoroutines waits for goroutines count to drop to specified value.
+func waitGoroutines(count int) int {
+	n := runtime.NumGoroutine()
+	for i := 0; i < 100 && n > count; i++ {
+		time.Sleep(10 * time.Millisecond)
+		n = runtime.NumGoroutine()
+	}
+
+	return n
+}
+
+func TestSharedSSTDEGRefCount(t *testing.T) {
+	first := AcquireSSTDEG()
+	second := AcquireSSTDEG()
+
+	if first.instance != second.instance {
+		t.Fatal("References should share the same instance")
+	}
+	if _, refs := sharedRefs(); refs != 2 {
+		t.Fatalf("Should count 2 references: got %d", refs)
+	}
+
+	first.Close()
+	first.Close()
+	if instance, refs := sharedRefs(); refs != 1 || instance == nil {
+		t.Fatalf("Releasing twice should release once: got %d references",
+			refs)
+	}
+	if n, err := first.Read(make([]byte, 1)); err != ErrClosed || n != 0 {
+		t.Errorf("Released reference should return ErrClosed: got %d and %v",
+			n, err)
+	}
+
+	instance := second.instance
+	second.Close()
+	if instance, refs := sharedRefs(); refs != 0 || instance != nil {
+		t.Fatalf("Shared instance should be released: got %d references",
+			refs)
+	}
+	select {
+	case <-instance.done:
+	default:
+		t.Error("Shared instance generator should be stopped")
+	}
+}
+
+func TestSharedSSTDEGLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	aggrs := make([]*RandomAggr, 0)
+	for i := 0; i < 10; i++ {
+		aggrs = append(aggrs, NewRandomAggr().SecureSet())
+		aggrs = append(aggrs, NewRandomAggr().InsecureSet())
+		aggrs = append(aggrs, NewRandomAggr().AddSSTDEG(1).Build())
+	}
+
+	if n := runtime.NumGoroutine(); n > before+1 {
+		t.Errorf("Aggregators should share one generator: %d new goroutines",
+			n-before)
+	}
+
+	for _, v := range aggrs {
+		v.Close()
+	}
+
+	if n := waitGoroutines(before); n > before {
+		t.Errorf("Generator routine leaked: %d goroutines instead of %d",
+			n, before)
+	}
+	if instance, refs := sharedRefs(); refs != 0 || instance != nil {
+		t.Errorf("Shared instance should be released: got %d references",
+			refs)
+	}
+}
+
+func TestSharedSSTDEGBuildLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	builders := []RandomAggrBuilder{
+		NewRandomAggr().AddSSTDEG(1).Add(nil, 1),
+		NewRandomAggr().AddFortuna(1).AddJitter(0),
+		NewRandomAggr().AddSSTDEG(1).AddSSTDEG(-1),
+		NewRandomAggr().AddSSTDEG(1).Interleave(-1),
+	}
+	for i, v := range builders {
+		if _, err := v.BuildE(); err == nil {
+			t.Errorf("Test %d: BuildE should fail", i)
+		}
+	}
+
+	rnd := NewRandomAggr().AddSys(1).Build()
+	rnd.ReplaceSources(NewRandomAggr().AddSSTDEG(1).Add(nil, 1))
+	rnd.Close()
+
+	if instance, refs := sharedRefs(); refs != 0 || instance != nil {
+		t.Errorf("Shared instance should be released: got %d references",
+			refs)
+	}
+	if n := waitGoroutines(before); n > before {
+		t.Errorf("Generator routine leaked: %d goroutines instead of %d",
+			n, before)
+	}
+}
+
+func TestSharedSSTDEGConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rnd := AcquireSSTDEG()
+			rnd.EntropyAvailable()
+			rnd.Close()
+		}()
+	}
+
+	wg.Wait()
+	if instance, refs := sharedRefs(); refs != 0 || instance != nil {
+		t.Errorf("Shared instance should be released: got %d references",
+			refs)
+	}
+}
+
+func TestSharedSSTDEGMetrics(t *testing.T) {
+	reg := NewMetricsRegistry()
+	SetSharedSSTDEGMetrics(reg)
+	defer SetSharedSSTDEGMetrics(nil)
+
+	rnd := NewRandomAggr().AddSSTDEG(1).Build()
+	defer rnd.Close()
+	if _, err := rnd.Read(make([]byte, 10)); err != nil {
+		t.Fatalf("Error reading RandomAggr: %v", err)
+	}
+
+	server := httptest.NewServer(reg)
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatalf("Error requesting metrics: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Error reading metrics: %v", err)
+	}
+
+	for _, v := range []string{
+		"# TYPE " + MetricSSTDEGSamples + " counter",
+		"# TYPE " + MetricSSTDEGPoolSize + " gauge",
+	} {
+		if !strings.Contains(string(body), v) {
+			t.Errorf("Shared instance should report %q:\n%s", v, body)
+		}
+	}
+}
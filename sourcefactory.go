@@ -0,0 +1,120 @@
+/*
+ * Copyright (C) 2016 Fabrício Godoy <skarllot@gmail.com>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+ */
+package crypt
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrMissingArgument is returned by a SourceFactory which requires an
+	// argument.
+	ErrMissingArgument = errors.New("source kind requires an argument")
+
+	// ErrUnexpectedArgument is returned by a SourceFactory which takes no
+	// argument.
+	ErrUnexpectedArgument = errors.New("source kind takes no argument")
+)
+
+// A SourceFactory creates a reader of random data from an optional argument,
+// like a device path.
+type SourceFactory func(arg string) (io.Reader, error)
+
+// sourceKinds holds registered source kinds of RandomAggrConfig.
+var sourceKinds = struct {
+	mutex     sync.RWMutex
+	factories map[string]SourceFactory
+}{
+	factories: map[string]SourceFactory{
+		"file": func(arg string) (io.Reader, error) {
+			if arg == "" {
+				return nil, ErrMissingArgument
+			}
+			return NewDevice(arg), nil
+		},
+		"fortuna": noArgument(func() io.Reader {
+			return NewFortuna(rand.Reader, NewCPUJitter(), AcquireSSTDEG())
+		}),
+		"getrandom": func(arg string) (io.Reader, error) {
+			flags := 0
+			for _, v := range strings.Split(arg, "|") {
+				switch v {
+				case "":
+				case "nonblock":
+					flags |= GrndNonblock
+				case "random":
+					flags |= GrndRandom
+				default:
+					return nil, ErrInvalidOption
+				}
+			}
+			return NewGetrandom(flags), nil
+		},
+		"jitter": noArgument(func() io.Reader {
+			return NewCPUJitter()
+		}),
+		"replay": func(arg string) (io.Reader, error) {
+			if arg == "" {
+				return nil, ErrMissingArgument
+			}
+			replay, err := OpenReplay(arg)
+			if err != nil {
+				return nil, err
+			}
+			return replay, nil
+		},
+		"sstdeg": noArgument(func() io.Reader {
+			return AcquireSSTDEG()
+		}),
+		"sys": noArgument(func() io.Reader {
+			return rand.Reader
+		}),
+	},
+}
+
+// RegisterSourceKind registers a custom source kind for RandomAggrConfig,
+// replacing any kind with the same name.
+func RegisterSourceKind(kind string, factory SourceFactory) {
+	sourceKinds.mutex.Lock()
+	defer sourceKinds.mutex.Unlock()
+
+	sourceKinds.factories[kind] = factory
+}
+
+// lookupSourceKind returns the factory of specified source kind.
+func lookupSourceKind(kind string) (SourceFactory, bool) {
+	sourceKinds.mutex.RLock()
+	defer sourceKinds.mutex.RUnlock()
+
+	factory, ok := sourceKinds.factories[kind]
+	return factory, ok
+}
+
+// noArgument creates a SourceFactory which rejects any argument.
+func noArgument(create func() io.Reader) SourceFactory {
+	return func(arg string) (io.Reader, error) {
+		if arg != "" {
+			return nil, ErrUnexpectedArgument
+		}
+		return create(), nil
+	}
+}
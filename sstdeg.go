@@ -19,8 +19,12 @@
 package crypt
 
 import (
+	"crypto/sha256"
+	"errors"
 	"io"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -30,128 +34,321 @@ const (
 
 	// defaultPoolSize defines the size of entropy pool for SSTDEG.
 	defaultPoolSize = 4096
+
+	// healthRepetitionCutoff defines how many times a sample can be repeated
+	// consecutively before it is considered a health test failure.
+	healthRepetitionCutoff = 21
 )
 
+// ErrClosed is returned by methods called after Close.
+var ErrClosed = errors.New("instance is closed")
+
+// SSTDEGOptions defines how a SSTDEG gathers entropy.
+type SSTDEGOptions struct {
+	// Clock measures sleep time deltas. Defaults to system clock.
+	Clock Clock
+
+	// Collectors defines how many independent routines gather samples to
+	// fill the entropy pool. Defaults to one.
+	Collectors int
+
+	// LockThread pins each collector routine to its own system thread.
+	LockThread bool
+
+	// Metrics receives operational metrics. Defaults to discard them.
+	Metrics Metrics
+}
+
 // A SSTDEG (System Sleep Time Delta Entropy Gathering) provides a pseudo-random
 // generator based on unpredictable syscall time deltas of sleep calls.
 type SSTDEG struct {
-	pool  [defaultPoolSize]byte
-	size  int
-	mutex *sync.Mutex
-	stop  chan bool
+	pool     entropyPool
+	chain    [sha256.Size]byte
+	credit   int
+	closed   bool
+	failures int
+	clock    Clock
+	metrics  Metrics
+	mutex    *sync.Mutex
+	once     *sync.Once
+	stop     chan bool
+	done     chan bool
 }
 
 // NewSSTDEG creates a new instance of SSTDEG.
 func NewSSTDEG() *SSTDEG {
+	return NewSSTDEGWithClock(systemClock{})
+}
+
+// NewSSTDEGWithClock creates a new instance of SSTDEG which uses specified
+// clock to measure sleep time deltas.
+func NewSSTDEGWithClock(clock Clock) *SSTDEG {
+	return NewSSTDEGWithOptions(SSTDEGOptions{Clock: clock})
+}
+
+// NewSSTDEGWithOptions creates a new instance of SSTDEG as specified by
+// options.
+func NewSSTDEGWithOptions(opts SSTDEGOptions) *SSTDEG {
+	if opts.Clock == nil {
+		opts.Clock = systemClock{}
+	}
+	if opts.Collectors < 1 {
+		opts.Collectors = 1
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = nopMetrics{}
+	}
+
 	result := &SSTDEG{
-		size:  0,
-		mutex: &sync.Mutex{},
-		stop:  make(chan bool, 0),
+		clock:   opts.Clock,
+		metrics: opts.Metrics,
+		mutex:   &sync.Mutex{},
+		once:    &sync.Once{},
+		stop:    make(chan bool, 0),
+		done:    make(chan bool, 0),
 	}
 
-	go result.generator()
+	running := int32(opts.Collectors)
+	for i := 0; i < opts.Collectors; i++ {
+		go result.collector(opts.LockThread, &running)
+	}
 	time.Sleep(defaultSleepTime)
 
 	return result
 }
 
-// Close stops background routine that fills entropy pool.
+// Close stops background routines that fill entropy pool, waits for them to
+// exit and erases the entropy pool. It is safe to call Close concurrently and
+// more than once.
 func (s *SSTDEG) Close() error {
-	if s.mutex == nil {
-		return nil
-	}
+	s.once.Do(func() {
+		s.mutex.Lock()
+		s.closed = true
+		s.mutex.Unlock()
+
+		close(s.stop)
+		<-s.done
 
-	s.stop <- true
+		s.mutex.Lock()
+		s.pool.erase()
+		s.chain = [sha256.Size]byte{}
+		s.credit = 0
+		s.mutex.Unlock()
+	})
 
-	s.mutex = nil
-	s.size = 0
-	s.stop = nil
 	return nil
 }
 
 // EntropyAvailable returns the entropy pool size of current instance.
+// Returns zero when current instance is closed.
 func (s *SSTDEG) EntropyAvailable() int {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return s.size
+	if s.closed {
+		return 0
+	}
+
+	return s.pool.size
+}
+
+// SetMetrics sets the receiver of operational metrics of current instance.
+func (s *SSTDEG) SetMetrics(m Metrics) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if m == nil {
+		m = nopMetrics{}
+	}
+	s.metrics = m
+	s.metrics.Set(MetricSSTDEGPoolSize, "", int64(s.pool.size))
 }
 
-// pop removes the n-elements from pool if available.
-func (s *SSTDEG) pop(b []byte) bool {
+// pop removes up to len(b) elements from pool and returns how many were
+// removed.
+func (s *SSTDEG) pop(b []byte) (int, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	l := len(b)
 
-	if s.size >= l {
-		copy(b, s.pool[s.size-l:s.size])
-		s.size -= l
-		return true
+	if s.closed {
+		return 0, ErrClosed
 	}
 
-	return false
+	n := s.pool.take(b)
+	s.metrics.Set(MetricSSTDEGPoolSize, "", int64(s.pool.size))
+	return n, nil
 }
 
-// Read fills specified byte array with random data.
-// Always return parameter array length and no errors.
-func (s *SSTDEG) Read(b []byte) (n int, err error) {
-	var chunk []byte
-	if len(b) > defaultPoolSize {
-		chunk = b[:defaultPoolSize]
+// push adds a sample to entropy pool. When pool is full the sample is mixed
+// into existing data.
+func (s *SSTDEG) push(n byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.metrics.Add(MetricSSTDEGSamples, "", 1)
+	if s.pool.add(n) {
+		s.metrics.Add(MetricSSTDEGOverflows, "", 1)
 	} else {
-		chunk = b
+		s.metrics.Set(MetricSSTDEGPoolSize, "", int64(s.pool.size))
 	}
+}
 
-	ok := s.pop(chunk)
+// fail records a health test failure.
+func (s *SSTDEG) fail() {
+	s.mutex.Lock()
+	s.failures++
+	s.metrics.Add(MetricSSTDEGHealthFailures, "", 1)
+	s.mutex.Unlock()
+}
 
-	for !ok {
-		time.Sleep(defaultSleepTime)
-		ok = s.pop(chunk)
+// wait blocks until entropy pool is refilled. Returns ErrClosed if current
+// instance was closed.
+func (s *SSTDEG) wait() error {
+	before := s.clock.Now()
+	defer func() {
+		s.mutex.Lock()
+		s.metrics.Add(MetricSSTDEGBlockedRead, "",
+			s.clock.Now().Sub(before).Nanoseconds())
+		s.mutex.Unlock()
+	}()
+
+	select {
+	case <-s.stop:
+		return ErrClosed
+	case <-s.clock.After(defaultSleepTime):
+		return nil
+	}
+}
+
+// Read fills specified byte array with random data. Requests larger than
+// entropy pool are served by draining the pool and waiting for it to be
+// refilled.
+//
+// Returns ErrClosed when current instance is closed before filling the buffer.
+func (s *SSTDEG) Read(b []byte) (n int, err error) {
+	for n < len(b) {
+		var c int
+		c, err = s.pop(b[n:])
+		if err == nil && c == 0 {
+			err = s.wait()
+		}
+		if err != nil {
+			return
+		}
+
+		n += c
 	}
 
-	return len(chunk), err
+	return n, nil
 }
 
-// generator fills entropy pool for this instance.
+// WriteTo writes a continuous stream of random data to w until current
+// instance is closed or an error occurs. It implements io.WriterTo interface
+// to allow io.Copy to stream data without intermediate buffering.
+//
+// Returns ErrClosed when current instance is closed.
+func (s *SSTDEG) WriteTo(w io.Writer) (n int64, err error) {
+	buf := make([]byte, defaultPoolSize)
+
+	for {
+		var c int
+		c, err = s.pop(buf)
+		if err == nil && c == 0 {
+			err = s.wait()
+			if err == nil {
+				continue
+			}
+		}
+		if err != nil {
+			return
+		}
+
+		var written int
+		written, err = w.Write(buf[:c])
+		n += int64(written)
+		if err != nil {
+			return
+		}
+		if written < c {
+			return n, io.ErrShortWrite
+		}
+	}
+}
+
+// collector runs a generator and signals when last running generator exits.
+func (s *SSTDEG) collector(lockThread bool, running *int32) {
+	defer func() {
+		if atomic.AddInt32(running, -1) == 0 {
+			close(s.done)
+		}
+	}()
+
+	if lockThread {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+	}
+
+	s.generator()
+}
+
+// generator fills entropy pool for this instance. Each running generator
+// applies health tests to its own samples.
 func (s *SSTDEG) generator() {
 	var rndBits [2]byte
 	var index byte
-	var overflowCounter int
+	var health repetitionTest
 
 	for {
 		rndDuration := time.Duration(getUInt16FromBytes(rndBits))
-		before := time.Now()
+		before := s.clock.Now()
 
 		select {
-		case <-time.After(defaultSleepTime + rndDuration):
-			diff := time.Now().Sub(before)
+		case <-s.clock.After(defaultSleepTime + rndDuration):
+			diff := s.clock.Now().Sub(before)
 			n := byte(diff.Nanoseconds() / 100)
 
+			if !health.check(n) {
+				s.fail()
+				continue
+			}
+
 			rndBits[index] = n
 			index ^= 1
 
-			s.mutex.Lock()
-			if s.size < defaultPoolSize {
-				s.pool[s.size] = n
-				s.size++
-			} else {
-				if overflowCounter == defaultPoolSize {
-					overflowCounter = 0
-				}
-
-				s.pool[overflowCounter] ^= n
-				overflowCounter++
-			}
-			s.mutex.Unlock()
+			s.push(n)
 		case <-s.stop:
 			return
 		}
 	}
 }
 
+// A repetitionTest implements the Repetition Count Test from NIST SP 800-90B,
+// which detects a noise source stuck at a single value.
+type repetitionTest struct {
+	last  byte
+	count int
+}
+
+// check returns false when the sample was repeated too many times.
+func (t *repetitionTest) check(n byte) bool {
+	if t.count > 0 && n == t.last {
+		t.count++
+	} else {
+		t.last = n
+		t.count = 1
+	}
+
+	return t.count < healthRepetitionCutoff
+}
+
 // getUInt16FromBytes convert a 2-byte array to 16-bit unsigned integer.
 func getUInt16FromBytes(input [2]byte) uint16 {
 	return uint16(input[0]) + uint16(input[1])*256
 }
 
 var _ io.ReadCloser = (*SSTDEG)(nil)
+var _ io.WriterTo = (*SSTDEG)(nil)
@@ -21,6 +21,9 @@ package crypt
 import (
 	"crypto/rand"
 	"io"
+	"io/ioutil"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -96,11 +99,86 @@ func TestSSTDEGUnpredictability(t *testing.T) {
 }
 
 func TestSSTDEGFillEntropyBuffer(t *testing.T) {
-	rnd := NewSSTDEG()
+	clock := newFakeClock(sequence(defaultPoolSize)...)
+	rnd := NewSSTDEGWithClock(clock)
 	defer rnd.Close()
 
-	for rnd.EntropyAvailable() < defaultPoolSize {
-		time.Sleep(defaultSleepTime)
+	clock.wait()
+	if n := rnd.EntropyAvailable(); n != defaultPoolSize {
+		t.Fatalf("Entropy pool should be full: got %d", n)
+	}
+
+	for i, v := range rnd.pool.data {
+		if v != byte(i) {
+			t.Fatalf("Unexpected pool value at %d: got %d instead of %d",
+				i, v, byte(i))
+		}
+	}
+}
+
+func TestSSTDEGOverflow(t *testing.T) {
+	samples := append(sequence(defaultPoolSize), 7, 8, 9)
+	clock := newFakeClock(samples...)
+	rnd := NewSSTDEGWithClock(clock)
+	defer rnd.Close()
+
+	clock.wait()
+	if n := rnd.EntropyAvailable(); n != defaultPoolSize {
+		t.Fatalf("Entropy pool should be full: got %d", n)
+	}
+
+	expected := []byte{0 ^ 7, 1 ^ 8, 2 ^ 9, 3}
+	for i, v := range expected {
+		if rnd.pool.data[i] != v {
+			t.Errorf("Overflow should XOR into pool at %d: got %d instead of %d",
+				i, rnd.pool.data[i], v)
+		}
+	}
+}
+
+func TestSSTDEGRandomSleep(t *testing.T) {
+	clock := newFakeClock(1, 2, 3)
+	rnd := NewSSTDEGWithClock(clock)
+	defer rnd.Close()
+
+	clock.wait()
+	expected := []time.Duration{
+		defaultSleepTime,
+		defaultSleepTime + 1,
+		defaultSleepTime + 1 + 2*256,
+		defaultSleepTime + 3 + 2*256,
+	}
+
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	for i, v := range expected {
+		if clock.requested[i] != v {
+			t.Errorf("Unexpected sleep time at %d: got %v instead of %v",
+				i, clock.requested[i], v)
+		}
+	}
+}
+
+func TestSSTDEGHealthFailure(t *testing.T) {
+	samples := make([]byte, healthRepetitionCutoff+9)
+	for i := range samples {
+		samples[i] = 5
+	}
+	samples = append(samples, 6)
+
+	clock := newFakeClock(samples...)
+	rnd := NewSSTDEGWithClock(clock)
+	defer rnd.Close()
+
+	clock.wait()
+	if n := rnd.EntropyAvailable(); n != healthRepetitionCutoff {
+		t.Errorf("Should discard repeated samples: got %d samples", n)
+	}
+
+	rnd.mutex.Lock()
+	defer rnd.mutex.Unlock()
+	if rnd.failures != 10 {
+		t.Errorf("Should record health test failures: got %d", rnd.failures)
 	}
 }
 
@@ -141,3 +219,279 @@ func BenchmarkSSTDEGWait(b *testing.B) {
 	b.StopTimer()
 	rnd.Close()
 }
+
+// newFedSSTDEG creates a SSTDEG instance whose entropy pool is fed by a test
+// routine instead of sleep time deltas.
+func newFedSSTDEG() *SSTDEG {
+	result := &SSTDEG{
+		clock:   systemClock{},
+		metrics: nopMetrics{},
+		mutex:   &sync.Mutex{},
+		once:    &sync.Once{},
+		stop:    make(chan bool),
+		done:    make(chan bool),
+	}
+
+	go func() {
+		defer close(result.done)
+
+		var n byte
+		for {
+			select {
+			case <-result.stop:
+				return
+			default:
+				result.push(n)
+				n++
+			}
+		}
+	}()
+
+	return result
+}
+
+type limitedWriter struct {
+	size int
+}
+
+func (w *limitedWriter) Write(b []byte) (int, error) {
+	if len(b) > w.size {
+		n := w.size
+		w.size = 0
+		return n, io.ErrShortWrite
+	}
+
+	w.size -= len(b)
+	return len(b), nil
+}
+
+func TestSSTDEGReadLarge(t *testing.T) {
+	rnd := newFedSSTDEG()
+	defer rnd.Close()
+
+	buff := make([]byte, defaultPoolSize*3+1)
+	n, err := rnd.Read(buff)
+	if err != nil {
+		t.Fatalf("Error reading SSTDEG: %v", err)
+	}
+	if n != len(buff) {
+		t.Errorf("Should fill entire buffer: read %d of %d bytes",
+			n, len(buff))
+	}
+}
+
+func TestSSTDEGReadClosed(t *testing.T) {
+	rnd := &SSTDEG{
+		clock:   systemClock{},
+		metrics: nopMetrics{},
+		mutex:   &sync.Mutex{},
+		once:    &sync.Once{},
+		stop:    make(chan bool),
+		done:    make(chan bool),
+	}
+	close(rnd.done)
+	rnd.push(1)
+
+	go func() {
+		time.Sleep(time.Millisecond)
+		rnd.Close()
+	}()
+
+	buff := make([]byte, 2)
+	n, err := rnd.Read(buff)
+	if err != ErrClosed {
+		t.Errorf("Should return ErrClosed when closed: got %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Should read available data before closing: read %d bytes",
+			n)
+	}
+}
+
+func TestSSTDEGReadClock(t *testing.T) {
+	clock := newFakeClock(1, 2)
+	rnd := NewSSTDEGWithClock(clock)
+
+	clock.wait()
+	go func() {
+		clock.waitBlocked(2)
+		rnd.Close()
+	}()
+
+	n, err := rnd.Read(make([]byte, 3))
+	if err != ErrClosed {
+		t.Errorf("Should return ErrClosed when closed: got %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Should read available data before closing: read %d bytes",
+			n)
+	}
+
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	if last := clock.requested[len(clock.requested)-1]; last != defaultSleepTime {
+		t.Errorf("Blocked read should wait using clock: got %v", last)
+	}
+}
+
+func TestSSTDEGWriteTo(t *testing.T) {
+	rnd := newFedSSTDEG()
+	defer rnd.Close()
+
+	w := &limitedWriter{defaultPoolSize*2 + 10}
+	n, err := io.Copy(w, rnd)
+	if err != io.ErrShortWrite {
+		t.Errorf("Should return writer error: got %v", err)
+	}
+	if n != defaultPoolSize*2+10 {
+		t.Errorf("Should stream until writer fails: wrote %d bytes", n)
+	}
+}
+
+func TestSSTDEGWriteToClosed(t *testing.T) {
+	rnd := newFedSSTDEG()
+
+	go func() {
+		time.Sleep(time.Millisecond)
+		rnd.Close()
+	}()
+
+	_, err := rnd.WriteTo(ioutil.Discard)
+	if err != ErrClosed {
+		t.Errorf("Should return ErrClosed when closed: got %v", err)
+	}
+}
+
+func TestSSTDEGCloseTwice(t *testing.T) {
+	rnd := NewSSTDEG()
+
+	if err := rnd.Close(); err != nil {
+		t.Fatalf("Error closing SSTDEG: %v", err)
+	}
+	if err := rnd.Close(); err != nil {
+		t.Fatalf("Error closing SSTDEG twice: %v", err)
+	}
+
+	select {
+	case <-rnd.done:
+	default:
+		t.Error("Close should wait for generator routine to exit")
+	}
+}
+
+func TestSSTDEGClosedMethods(t *testing.T) {
+	rnd := newFedSSTDEG()
+	for rnd.EntropyAvailable() < defaultPoolSize {
+		time.Sleep(time.Millisecond)
+	}
+	rnd.Close()
+
+	if n := rnd.EntropyAvailable(); n != 0 {
+		t.Errorf("Closed instance should have no entropy: got %d", n)
+	}
+	for i, v := range rnd.pool.data {
+		if v != 0 {
+			t.Fatalf("Entropy pool should be erased: %d at %d", v, i)
+		}
+	}
+
+	n, err := rnd.Read(make([]byte, 1))
+	if err != ErrClosed || n != 0 {
+		t.Errorf("Read should return ErrClosed: got %d and %v", n, err)
+	}
+
+	_, err = rnd.WriteTo(ioutil.Discard)
+	if err != ErrClosed {
+		t.Errorf("WriteTo should return ErrClosed: got %v", err)
+	}
+}
+
+func TestSSTDEGConcurrentClose(t *testing.T) {
+	rnd := newFedSSTDEG()
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			rnd.Read(make([]byte, defaultPoolSize*2))
+		}()
+		go func() {
+			defer wg.Done()
+			rnd.EntropyAvailable()
+		}()
+		go func() {
+			defer wg.Done()
+			rnd.Close()
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestSSTDEGCollectors(t *testing.T) {
+	clock := newFakeClock(sequence(defaultPoolSize)...)
+	rnd := NewSSTDEGWithOptions(SSTDEGOptions{
+		Clock:      clock,
+		Collectors: 4,
+		LockThread: true,
+	})
+	defer rnd.Close()
+
+	clock.waitBlocked(4)
+	if n := rnd.EntropyAvailable(); n != defaultPoolSize {
+		t.Errorf("Collectors should fill entropy pool: got %d", n)
+	}
+}
+
+func TestSSTDEGCollectorsHealth(t *testing.T) {
+	samples := make([]byte, healthRepetitionCutoff*4)
+	for i := range samples {
+		samples[i] = 5
+	}
+
+	clock := newFakeClock(samples...)
+	rnd := NewSSTDEGWithOptions(SSTDEGOptions{
+		Clock:      clock,
+		Collectors: 2,
+	})
+	defer rnd.Close()
+
+	clock.waitBlocked(2)
+	accepted := rnd.EntropyAvailable()
+	if accepted < healthRepetitionCutoff-1 ||
+		accepted > (healthRepetitionCutoff-1)*2 {
+		t.Errorf("Each collector should test its own samples: accepted %d",
+			accepted)
+	}
+
+	rnd.mutex.Lock()
+	defer rnd.mutex.Unlock()
+	if rnd.failures != len(samples)-accepted {
+		t.Errorf("Should record health test failures: got %d", rnd.failures)
+	}
+}
+
+func BenchmarkSSTDEGCollectors(b *testing.B) {
+	for _, count := range []int{1, 2, 4, 8} {
+		b.Run(strconv.Itoa(count), func(b *testing.B) {
+			rnd := NewSSTDEGWithOptions(SSTDEGOptions{
+				Collectors: count,
+				LockThread: true,
+			})
+			buff := make([]byte, b.N)
+			b.ResetTimer()
+
+			n, err := io.ReadFull(rnd, buff)
+			if err != nil {
+				b.Fatalf("Error reading SSTDEG: %v", err)
+			} else if n < len(buff) {
+				b.Fatalf("Error reading SSTDEG: should read %d bytes but read %d",
+					len(buff), n)
+			}
+
+			b.StopTimer()
+			rnd.Close()
+		})
+	}
+}